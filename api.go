@@ -0,0 +1,289 @@
+package xxc
+
+// chat 模块的方法，每个方法一个结构，负责生成按位置排列的参数，并解析返回数据
+type ChatAPI interface {
+	Method() string
+	Params() interface{}
+}
+
+func NewChatRequest(userID int, api ChatAPI) *Request {
+	return &Request{
+		UserID: userID,
+		Module: "chat",
+		Method: api.Method(),
+		Params: api.Params(),
+	}
+}
+
+func decodeUserProfile(resp *Response) (*UserProfile, error) {
+	profile := &UserProfile{}
+	if err := resp.ConvertDataTo(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func decodeChatGroup(resp *Response) (*ChatGroup, error) {
+	group := &ChatGroup{}
+	if err := resp.ConvertDataTo(group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func decodeChatGroups(resp *Response) ([]*ChatGroup, error) {
+	var groups []*ChatGroup
+	if err := resp.ConvertDataTo(&groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func decodeChatMessages(resp *Response) ([]*ChatMessage, error) {
+	var messages []*ChatMessage
+	if err := resp.ConvertDataTo(&messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// 登录
+type LoginAPI struct {
+	Account  string
+	Password string // md5 后的密码或会话 token
+	Status   string // online, busy, away
+}
+
+func (a *LoginAPI) Method() string { return "login" }
+
+func (a *LoginAPI) Params() interface{} {
+	status := a.Status
+	if status == "" {
+		status = "online"
+	}
+	return []interface{}{"", a.Account, a.Password, status}
+}
+
+func (a *LoginAPI) Decode(resp *Response) (*UserProfile, error) {
+	return decodeUserProfile(resp)
+}
+
+// 退出登录
+type LogoutAPI struct{}
+
+func (a *LogoutAPI) Method() string { return "logout" }
+
+func (a *LogoutAPI) Params() interface{} { return nil }
+
+// 获取所有用户
+type UserGetListAPI struct{}
+
+func (a *UserGetListAPI) Method() string { return "usergetlist" }
+
+func (a *UserGetListAPI) Params() interface{} { return nil }
+
+func (a *UserGetListAPI) Decode(resp *Response) ([]*UserProfile, error) {
+	var users []*UserProfile
+	if err := resp.ConvertDataTo(&users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// 获取部门列表
+type DeptGetListAPI struct{}
+
+func (a *DeptGetListAPI) Method() string { return "deptgetlist" }
+
+func (a *DeptGetListAPI) Params() interface{} { return nil }
+
+func (a *DeptGetListAPI) Decode(resp *Response) ([]*Dept, error) {
+	var depts []*Dept
+	if err := resp.ConvertDataTo(&depts); err != nil {
+		return nil, err
+	}
+	return depts, nil
+}
+
+// 获取当前用户的所有会话
+type GetListAPI struct{}
+
+func (a *GetListAPI) Method() string { return "getlist" }
+
+func (a *GetListAPI) Params() interface{} { return nil }
+
+func (a *GetListAPI) Decode(resp *Response) ([]*ChatGroup, error) {
+	return decodeChatGroups(resp)
+}
+
+// 获取会话成员
+type MembersAPI struct {
+	Gid string
+}
+
+func (a *MembersAPI) Method() string { return "members" }
+
+func (a *MembersAPI) Params() interface{} { return []interface{}{a.Gid} }
+
+func (a *MembersAPI) Decode(resp *Response) (*ChatGroup, error) {
+	return decodeChatGroup(resp)
+}
+
+// 创建会话
+type CreateAPI struct {
+	Gid     string
+	Name    string
+	Type    string // one2one, group
+	Members []int
+	Subject int  // 主题会话的关联主题ID
+	Public  bool // 是否公共会话
+}
+
+func (a *CreateAPI) Method() string { return "create" }
+
+func (a *CreateAPI) Params() interface{} {
+	return []interface{}{a.Gid, a.Name, a.Type, a.Members, a.Subject, a.Public}
+}
+
+func (a *CreateAPI) Decode(resp *Response) (*ChatGroup, error) {
+	return decodeChatGroup(resp)
+}
+
+// 加入或退出公共会话
+type JoinChatAPI struct {
+	Gid  string
+	Join bool // false 表示退出
+}
+
+func (a *JoinChatAPI) Method() string { return "joinchat" }
+
+func (a *JoinChatAPI) Params() interface{} { return []interface{}{a.Gid, a.Join} }
+
+func (a *JoinChatAPI) Decode(resp *Response) (*ChatGroup, error) {
+	return decodeChatGroup(resp)
+}
+
+// 修改会话名称
+type RenameAPI struct {
+	Gid  string
+	Name string
+}
+
+func (a *RenameAPI) Method() string { return "changename" }
+
+func (a *RenameAPI) Params() interface{} { return []interface{}{a.Gid, a.Name} }
+
+func (a *RenameAPI) Decode(resp *Response) (*ChatGroup, error) {
+	return decodeChatGroup(resp)
+}
+
+// 设置会话允许发言的用户
+type SetCommittersAPI struct {
+	Gid        string
+	Committers IntList
+}
+
+func (a *SetCommittersAPI) Method() string { return "setcommitters" }
+
+func (a *SetCommittersAPI) Params() interface{} { return []interface{}{a.Gid, a.Committers} }
+
+func (a *SetCommittersAPI) Decode(resp *Response) (*ChatGroup, error) {
+	return decodeChatGroup(resp)
+}
+
+// 收藏或取消收藏会话
+type StarAPI struct {
+	Gid  string
+	Star bool
+}
+
+func (a *StarAPI) Method() string { return "star" }
+
+func (a *StarAPI) Params() interface{} { return []interface{}{a.Gid, a.Star} }
+
+func (a *StarAPI) Decode(resp *Response) (*ChatGroup, error) {
+	return decodeChatGroup(resp)
+}
+
+// 隐藏或显示会话
+type HideAPI struct {
+	Gid  string
+	Hide bool
+}
+
+func (a *HideAPI) Method() string { return "hide" }
+
+func (a *HideAPI) Params() interface{} { return []interface{}{a.Gid, a.Hide} }
+
+func (a *HideAPI) Decode(resp *Response) (*ChatGroup, error) {
+	return decodeChatGroup(resp)
+}
+
+// 邀请或移除会话成员
+type AddMemberAPI struct {
+	Gid     string
+	Members []int
+	Join    bool // false 表示移除
+}
+
+func (a *AddMemberAPI) Method() string { return "addmember" }
+
+func (a *AddMemberAPI) Params() interface{} { return []interface{}{a.Gid, a.Members, a.Join} }
+
+func (a *AddMemberAPI) Decode(resp *Response) (*ChatGroup, error) {
+	return decodeChatGroup(resp)
+}
+
+// 发送消息
+type MessageAPI struct {
+	Messages []*ChatMessage
+}
+
+func (a *MessageAPI) Method() string { return "message" }
+
+type messageParams struct {
+	Messages []*ChatMessage `json:"messages"`
+}
+
+func (a *MessageAPI) Params() interface{} {
+	return &messageParams{
+		Messages: a.Messages,
+	}
+}
+
+func (a *MessageAPI) Decode(resp *Response) ([]*ChatMessage, error) {
+	return decodeChatMessages(resp)
+}
+
+// 获取会话历史消息
+type HistoryAPI struct {
+	Gid        string
+	RecPerPage int       // 每页消息数
+	PageID     int       // 页码，从 1 开始
+	RecTotal   int       // 消息总数，0 表示由服务器计算
+	Continued  bool      // 是否继续获取下一页
+	StartDate  Timestamp // 只获取此时间之后的消息，零值表示不限
+}
+
+func (a *HistoryAPI) Method() string { return "history" }
+
+func (a *HistoryAPI) Params() interface{} {
+	var startDate int64
+	if !a.StartDate.IsZero() {
+		startDate = a.StartDate.Unix()
+	}
+	return []interface{}{a.Gid, a.RecPerPage, a.PageID, a.RecTotal, a.Continued, startDate}
+}
+
+func (a *HistoryAPI) Decode(resp *Response) ([]*ChatMessage, error) {
+	return decodeChatMessages(resp)
+}
+
+// 标记会话消息已读
+type SetMessagesReadAPI struct {
+	Gid string
+}
+
+func (a *SetMessagesReadAPI) Method() string { return "setmessagesread" }
+
+func (a *SetMessagesReadAPI) Params() interface{} { return []interface{}{a.Gid} }
@@ -1,14 +1,12 @@
 package main
 
 import (
-	"errors"
 	"flag"
-	"fmt"
 	"log"
-	"strings"
 
 	"github.com/xjdrew/xxc"
-	"github.com/xjdrew/xxc/ext/tuling123"
+	"github.com/xjdrew/xxc/bot"
+	"github.com/xjdrew/xxc/ext/answer"
 )
 
 func init() {
@@ -16,115 +14,52 @@ func init() {
 }
 
 type TuringService struct {
-	user   *xxc.User
-	tuling *tuling123.Client
+	answer.Service
 }
 
-func (svc *TuringService) parseQuestion(message *xxc.ChatMessage) string {
-	// 不处理非文本信息
-	if message.ContentType != "text" {
-		return ""
-	}
-
-	if message.User == svc.user.GetProfile().Id {
-		return ""
-	}
-
-	group := svc.user.GetGroup(message.Cgid)
-	tag := "@" + svc.user.GetProfile().Realname
-	if group != nil && group.Type != "one2one" && strings.Index(message.Content, tag) == -1 {
-		return ""
-	}
-
-	// remove tag
-	content := message.Content
-	for {
-		index := strings.Index(content, tag)
-		if index == -1 {
-			break
-		}
-		content = content[:index] + content[index+len(tag):]
-	}
-	return content
-}
-
-func (svc *TuringService) handleOneMessage(message *xxc.ChatMessage) {
-	question := svc.parseQuestion(message)
-	if question == "" {
-		return
-	}
-
-	log.Printf("\tquestion: %s", question)
-	id := fmt.Sprintf("%d", message.User)
-	tresp, err := svc.tuling.Ask(question, id)
+// 断线后重新登录，并补齐断线期间的消息
+func (svc *TuringService) Run(account *xxc.AccountConfig) error {
+	manager, err := xxc.NewManager([]*xxc.AccountConfig{account})
 	if err != nil {
-		log.Printf("\ttuling answer failed: %s", err)
+		return err
 	}
-	answer := tresp.String()
-	log.Printf("\ttuling answer: %s", answer)
-	svc.user.SayToGroup(message.Cgid, answer)
-}
 
-func (svc *TuringService) OnChatMessage(resp *xxc.Response) {
-	var messages []*xxc.ChatMessage
-	err := resp.ConvertDataTo(&messages)
-	if err != nil {
-		log.Printf("OnChatMessage failed: %s", err)
-		return
+	router := bot.NewRouter()
+	svc.Register(router)
+	// 和以前一样回答还没有收到会话信息的消息
+	router.TrustUnknownGroups = true
+	manager.Online = func(user *xxc.User) {
+		log.Printf("login as user: %s", user.GetProfile().Account)
+		router.Attach(user)
 	}
-
-	if resp.Succeed() {
-		for _, m := range messages {
-			log.Printf("OnChatMessage: g<%s>, u<%d>, d<%d>, t<%s>, ct<%s>, c<%s>", m.Cgid, m.User, m.Date, m.Type, m.ContentType, m.Content)
-			svc.handleOneMessage(m)
-		}
-	} else {
-		log.Printf("OnChatMessage: <%s,%s>", resp.Result, resp.Message)
-	}
-}
-
-func (svc *TuringService) Run() error {
-	user := svc.user
-	done := make(chan error)
-	user.Client.Mux.HandleFunc("chat.message", svc.OnChatMessage)
-	user.Client.Mux.HandleFunc("chat.kickoff", func(resp *xxc.Response) {
-		done <- errors.New(resp.Message)
-	})
-	user.Client.HandleConnectionError(func(err error) {
-		done <- err
-	})
-	return <-done
+	return manager.Serve()
 }
 
 func main() {
-	config := &xxc.ClientConfig{}
+	account := &xxc.AccountConfig{}
 
-	var apiPath, apiKey string
+	answerConfig := &answer.Config{}
 
-	flag.StringVar(&config.Host, "host", "https://im.ejoy:11443", "http service")
-	flag.StringVar(&config.User, "user", "bot", "user name")
-	flag.StringVar(&config.Password, "password", "bot", "password")
+	flag.StringVar(&account.Host, "host", "https://im.ejoy:11443", "http service")
+	flag.StringVar(&account.User, "user", "bot", "user name")
+	flag.StringVar(&account.Password, "password", "", "password, no longer defaults to \"bot\"; visible to other local users, prefer -credentials or $XXC_PASSWORD")
+	flag.StringVar(&account.Credentials, "credentials", "", "file holding password, passwordHash or token")
+	flag.StringVar(&account.Trace, "trace", "", "record decrypted protocol frames to file")
 	flag.BoolVar(&xxc.Verbose, "verbose", false, "print debug information")
 
-	flag.StringVar(&apiPath, "apiPath", "http://www.tuling123.com/openapi/api", "tuling123 api interface")
-	flag.StringVar(&apiKey, "apiKey", "83aedbb664414469a42adbccb03ae137", "tuling123 api key")
+	answerConfig.AddFlags(flag.CommandLine)
+	svc := &TuringService{}
+	svc.AddFlags(flag.CommandLine)
 	flag.Parse()
 
-	client := xxc.NewClient(config)
-	user, err := xxc.CreateUser(client)
+	a, err := answerConfig.New()
 	if err != nil {
-		log.Fatalf("create user failed: %s", err)
+		log.Fatalf("create answerer failed: %s", err)
 	}
-	//defer user.Fini()
-	log.Printf("login as user: %s", user.GetProfile().Account)
-
-	svc := &TuringService{
-		user: user,
-		tuling: &tuling123.Client{
-			APIPath: apiPath,
-			APIKey:  apiKey,
-		},
+	if a == nil {
+		log.Fatalf("no answerer, set -apiKey or choose another -answerer")
 	}
+	svc.Answerer = a
 
-	log.Println(svc.Run())
+	log.Println(svc.Run(account))
 }
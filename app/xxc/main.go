@@ -15,18 +15,25 @@ func init() {
 func main() {
 	config := &xxc.ClientConfig{}
 
-	var account, groupid, message string
+	var account, groupid, message, credentials string
 
 	flag.StringVar(&config.Host, "host", "https://im.ejoy:11443", "http service")
 	flag.StringVar(&config.User, "user", "bot", "user name")
-	flag.StringVar(&config.Password, "password", "bot", "password")
+	flag.StringVar(&config.Password, "password", "", "password, no longer defaults to \"bot\"; visible to other local users, prefer -credentials or $XXC_PASSWORD")
+	flag.StringVar(&credentials, "credentials", "", "file holding password, passwordHash or token")
+	flag.StringVar(&config.Trace, "trace", "", "record decrypted protocol frames to file")
 	flag.BoolVar(&xxc.Verbose, "verbose", false, "print debug information")
 
 	flag.StringVar(&account, "r", "", "指定接收信息的用户")
-	flag.StringVar(&groupid, "g", "", "指定接收信息的组id")
+	flag.StringVar(&groupid, "g", "", "指定接收信息的组id或名称")
 	flag.StringVar(&message, "m", "", "消息的内容")
 	flag.Parse()
 
+	if err := xxc.LoadCredentials(config, credentials); err != nil {
+		log.Fatalf("load credentials failed: %s", err)
+	}
+	xxc.UnsetCredentialsEnv()
+
 	client := xxc.NewClient(config)
 	user, err := xxc.CreateUser(client)
 	if err != nil {
@@ -44,7 +51,10 @@ func main() {
 	}
 
 	if groupid != "" && message != "" {
-		err := user.SayToGroup(groupid, message)
+		group, err := user.ResolveGroup(groupid)
+		if err == nil {
+			err = user.SayToGroup(group.Gid, message)
+		}
 		if err != nil {
 			log.Printf("say failed: %s", err)
 		}
@@ -3,21 +3,35 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"sync/atomic"
 
 	"github.com/xjdrew/xxc"
 )
 
-var ErrUserOffline = errors.New("user is offline")
+var ErrNoOutbox = errors.New("outbox is not enabled")
 
 type SendMessageRequest struct {
+	Account string   `json:"account"` // 发送消息的机器人账号，为空时使用第一个账号
 	Users   []string `json:"users"`
-	Group   string   `json:"group"`
+	Depts   []string `json:"depts"` // 部门名称，发送给部门及下级部门的所有用户
+	Group   string   `json:"group"` // 会话 gid 或名称
 	Message string   `json:"message"`
 }
 
+type SendMessageResponse struct {
+	Result   string   `json:"result"`           // ok 或 partial，partial 表示有消息没有发出去
+	Messages []string `json:"messages"`         // 已写入连接的消息 gid，可以用于查询发送状态
+	Queued   []string `json:"queued,omitempty"` // 发送失败但进入了发件箱的消息 gid，重新登录后重发
+	Errors   []string `json:"errors,omitempty"`
+}
+
+type MessageStatusRequest struct {
+	Account string `json:"account"`
+	Gid     string `json:"gid"`
+}
+
 type GeneralResponse struct {
 	Result  string `json:"result"` // "ok" or "failed"
 	Message string `json:"code"`   // 错误描述
@@ -61,8 +75,9 @@ func (f myHttpHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 }
 
 type Server struct {
-	Listen string
-	user   atomic.Value
+	Listen  string
+	Manager *xxc.Manager
+	Metrics http.Handler // 为空时不提供 /metrics
 }
 
 func parseTo(v interface{}, r *http.Request) error {
@@ -77,40 +92,110 @@ func (s *Server) handleSendMessage(r *http.Request) (interface{}, error) {
 		return nil, err
 	}
 
-	user := s.GetUser()
-	if user == nil {
-		return nil, ErrUserOffline
+	user, err := s.Manager.User(smr.Account)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, account := range smr.Users {
-		user.SayToUser(account, smr.Message)
+	resp := &SendMessageResponse{
+		Result: "ok",
 	}
 
+	accounts := smr.Users
+	if len(smr.Depts) > 0 {
+		if len(user.GetDepts()) == 0 {
+			user.ReloadDeptList()
+		}
+		for _, dept := range smr.Depts {
+			for _, profile := range user.FindUsersByDept(dept, true) {
+				accounts = append(accounts, profile.Account)
+			}
+		}
+	}
+
+	// 先确定所有要发送的会话，有一个无法确定就不发送，避免发了一部分后才发现会话不存在
+	var target *xxc.ChatGroup
 	if smr.Group != "" {
-		user.SayToGroup(smr.Group, smr.Message)
+		target, err = user.ResolveGroup(smr.Group)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return nil, nil
-}
 
-func (s *Server) handleGetUserList(r *http.Request) (interface{}, error) {
-	user := s.GetUser()
-	if user == nil {
-		return nil, ErrUserOffline
+	var gids []string
+	resolved := make(map[string]bool)
+	for _, name := range accounts {
+		// 可以是账号，也可以是姓名、邮箱或手机号
+		profile, err := user.ResolveUser(name)
+		if err != nil {
+			return nil, fmt.Errorf("resolve user %s failed: %s", name, err)
+		}
+		if resolved[profile.Account] {
+			continue
+		}
+		resolved[profile.Account] = true
+
+		group, err := user.CreateOne2OneGroup(profile.Id)
+		if err != nil {
+			return nil, fmt.Errorf("create one2one group with %s failed: %s", name, err)
+		}
+		gids = append(gids, group.Gid)
 	}
-	return user.ReloadUserList(), nil
+	if target != nil {
+		gids = append(gids, target.Gid)
+	}
+
+	for _, gid := range gids {
+		m, err := user.QueueMessage(gid, xxc.Text(smr.Message))
+		switch {
+		case err == nil:
+			resp.Messages = append(resp.Messages, m.Gid)
+		case m != nil:
+			resp.Queued = append(resp.Queued, m.Gid)
+		}
+		if err != nil {
+			log.Printf("send message to %s failed: %s", gid, err)
+			resp.Result = "partial"
+			resp.Errors = append(resp.Errors, fmt.Sprintf("%s: %s", gid, err))
+		}
+	}
+	return resp, nil
 }
 
-func (s *Server) SetUser(user *xxc.User) {
-	s.user.Store(user)
+func (s *Server) handleMessageStatus(r *http.Request) (interface{}, error) {
+	msr := &MessageStatusRequest{}
+	err := parseTo(msr, r)
+	if err != nil {
+		return nil, err
+	}
+
+	outbox := s.Manager.Outbox(msr.Account)
+	if outbox == nil {
+		return nil, ErrNoOutbox
+	}
+
+	entry := outbox.Status(msr.Gid)
+	if entry == nil {
+		return nil, fmt.Errorf("%s is not a valid message gid", msr.Gid)
+	}
+	return entry, nil
 }
 
-func (s *Server) GetUser() *xxc.User {
-	return s.user.Load().(*xxc.User)
+func (s *Server) handleGetUserList(r *http.Request) (interface{}, error) {
+	user, err := s.Manager.User(r.FormValue("account"))
+	if err != nil {
+		return nil, err
+	}
+	return user.ReloadUserList(), nil
 }
 
 func (s *Server) ListenAndServe() error {
 	log.Println("listen:", s.Listen)
 	http.Handle("/sendmessage", myHttpHandler(s.handleSendMessage))
 	http.Handle("/getuserlist", myHttpHandler(s.handleGetUserList))
+	http.Handle("/messagestatus", myHttpHandler(s.handleMessageStatus))
+	if s.Metrics != nil {
+		http.Handle("/metrics", s.Metrics)
+	}
 	return http.ListenAndServe(s.Listen, nil)
 }
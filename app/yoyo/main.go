@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"log"
+	"strings"
 
 	"github.com/xjdrew/xxc"
+	"github.com/xjdrew/xxc/ext/answer"
 )
 
 func init() {
@@ -12,30 +14,113 @@ func init() {
 }
 
 func main() {
-	xxu := &XXUser{}
+	account := &xxc.AccountConfig{}
 	turing := &TuringService{}
 	server := &Server{}
 
-	flag.StringVar(&xxu.Config.Host, "host", "https://im.ejoy:11443", "http service")
-	flag.StringVar(&xxu.Config.User, "user", "bot", "user name")
-	flag.StringVar(&xxu.Config.Password, "password", "bot", "password")
+	var accounts, turingAccount string
+	flag.StringVar(&accounts, "accounts", "", "json file listing bot accounts, overrides -host, -user, -password, -credentials and -outbox")
+
+	flag.StringVar(&account.Host, "host", "https://im.ejoy:11443", "http service")
+	flag.StringVar(&account.User, "user", "bot", "user name")
+	flag.StringVar(&account.Password, "password", "", "password, no longer defaults to \"bot\"; visible to other local users, prefer -credentials or $XXC_PASSWORD")
+	flag.StringVar(&account.Credentials, "credentials", "", "file holding password, passwordHash or token")
+	flag.StringVar(&account.Trace, "trace", "", "record decrypted protocol frames to file")
 	flag.BoolVar(&xxc.Verbose, "verbose", false, "print debug information")
 
-	flag.StringVar(&turing.Tuling.APIPath, "apiPath", "http://www.tuling123.com/openapi/api", "tuling123 api interface")
-	flag.StringVar(&turing.Tuling.APIKey, "apiKey", "", "tuling123 api key")
+	answerConfig := &answer.Config{}
+	answerConfig.AddFlags(flag.CommandLine)
+	turing.AddFlags(flag.CommandLine)
+	flag.StringVar(&turingAccount, "turingAccount", "", "account answering questions, empty means the first account")
 
 	flag.StringVar(&server.Listen, "listen", ":1954", "http listen address")
+
+	var schedules, scheduleState, scheduleAccount string
+	flag.StringVar(&schedules, "schedules", "", "json file listing scheduled messages")
+	flag.StringVar(&scheduleState, "scheduleState", "", "file recording scheduled messages and when they were sent, empty means not persisted")
+	flag.StringVar(&scheduleAccount, "scheduleAccount", "", "account sending scheduled messages, empty means the first account")
+
+	flag.StringVar(&account.Outbox, "outbox", "", "spool directory for unsent messages, empty means disabled")
+
+	rateLimit := &xxc.RateLimitConfig{Wait: true}
+	flag.Float64Var(&rateLimit.Global.Rate, "rate", 10, "max requests per second sent to server, 0 means unlimited")
+	flag.IntVar(&rateLimit.Global.Burst, "burst", 20, "max burst requests sent to server")
+	flag.Float64Var(&rateLimit.PerGroup.Rate, "groupRate", 1, "max messages per second sent to one group, 0 means unlimited")
+	flag.IntVar(&rateLimit.PerGroup.Burst, "groupBurst", 5, "max burst messages sent to one group")
 	flag.Parse()
 
-	xxu.Online = func(user *xxc.User) {
-		turing.SetUser(user)
-		server.SetUser(user)
+	a, err := answerConfig.New()
+	if err != nil {
+		log.Fatalf("create answerer failed: %s", err)
+	}
+	turing.Answerer = a
+
+	configs := []*xxc.AccountConfig{account}
+	if accounts != "" {
+		configs, err = xxc.LoadAccountConfigs(accounts)
+		if err != nil {
+			log.Fatalf("load accounts failed: %s", err)
+		}
+	}
+
+	metrics := xxc.NewPrometheusMetrics()
+	for _, config := range configs {
+		config.Metrics = metrics
+		if config.RateLimit == nil {
+			config.RateLimit = rateLimit
+		}
+	}
+	server.Metrics = metrics
+
+	manager, err := xxc.NewManager(configs)
+	if err != nil {
+		log.Fatalf("create manager failed: %s", err)
 	}
+	server.Manager = manager
 
-	xxu.Offline = func(user *xxc.User) {
-		turing.SetUser(nil)
-		server.SetUser(nil)
+	scheduler, err := xxc.OpenScheduler(scheduleState)
+	if err != nil {
+		log.Fatalf("open scheduler failed: %s", err)
 	}
+	if schedules != "" {
+		l, err := xxc.LoadScheduledMessages(schedules)
+		if err == nil {
+			err = scheduler.Sync(l)
+		}
+		if err != nil {
+			log.Fatalf("load schedules failed: %s", err)
+		}
+	}
+
+	if turingAccount == "" {
+		turingAccount = manager.Accounts()[0]
+	}
+	if scheduleAccount == "" {
+		scheduleAccount = manager.Accounts()[0]
+	}
+	is := func(user *xxc.User, account string) bool {
+		return strings.EqualFold(user.GetProfile().Account, account)
+	}
+
+	manager.Online = func(user *xxc.User) {
+		if is(user, turingAccount) {
+			turing.SetUser(user)
+		}
+		if is(user, scheduleAccount) {
+			scheduler.SetUser(user)
+		}
+	}
+
+	manager.Offline = func(user *xxc.User) {
+		if is(user, turingAccount) {
+			turing.SetUser(nil)
+		}
+		if is(user, scheduleAccount) {
+			scheduler.SetUser(nil)
+		}
+	}
+
+	go scheduler.Run()
 
 	done := make(chan error)
 	go func() {
@@ -43,7 +128,7 @@ func main() {
 	}()
 
 	go func() {
-		done <- xxu.Serve()
+		done <- manager.Serve()
 	}()
 
 	log.Printf("finish: %s", <-done)
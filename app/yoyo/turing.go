@@ -1,111 +1,28 @@
 package main
 
 import (
-	"fmt"
-	"log"
-	"strings"
 	"sync/atomic"
 
 	"github.com/xjdrew/xxc"
-	"github.com/xjdrew/xxc/ext/tuling123"
+	"github.com/xjdrew/xxc/bot"
+	"github.com/xjdrew/xxc/ext/answer"
 )
 
 type TuringService struct {
-	user   atomic.Value
-	Tuling tuling123.Client
-}
-
-func (svc *TuringService) parseQuestion(user *xxc.User, message *xxc.ChatMessage) string {
-	// 不处理非文本信息
-	if message.ContentType != "text" {
-		return ""
-	}
-
-	if message.User == user.GetProfile().Id {
-		return ""
-	}
-
-	group := user.GetGroup(message.Cgid)
-	// 加强校验，如果创建组的信息未到达，放弃处理
-	if group == nil {
-		return ""
-	}
-
-	profile := user.GetProfile()
-
-	// 喧喧bug 1: 可能收到其他用户的one2one聊天信息
-	// 喧喧bug 2: 任何人都可以向其他用户的one2one会话里投递信息
-	if !group.IsInGroup(message.User) || !group.IsInGroup(profile.Id) {
-		return ""
-	}
-
-	tag := "@" + profile.Realname
-	if group.Type != "one2one" && strings.Index(message.Content, tag) == -1 {
-		return ""
-	}
-
-	// remove tag
-	content := message.Content
-	for {
-		index := strings.Index(content, tag)
-		if index == -1 {
-			break
-		}
-		content = content[:index] + content[index+len(tag):]
-	}
-	return content
-}
-
-func (svc *TuringService) handleOneMessage(message *xxc.ChatMessage) {
-	user := svc.GetUser()
-	if user == nil {
-		log.Println("no user")
-		return
-	}
-
-	question := svc.parseQuestion(user, message)
-	if question == "" {
-		return
-	}
-
-	log.Printf("\tquestion: %s", question)
-	id := fmt.Sprintf("%d", message.User)
-	tresp, err := svc.Tuling.Ask(question, id)
-	if err != nil {
-		log.Printf("\ttuling answer failed: %s", err)
-		return
-	}
-	answer := tresp.String()
-	log.Printf("\ttuling answer: %s", answer)
-	user.SayToGroup(message.Cgid, answer)
-}
-
-func (svc *TuringService) OnChatMessage(resp *xxc.Response) {
-	var messages []*xxc.ChatMessage
-	err := resp.ConvertDataTo(&messages)
-	if err != nil {
-		log.Printf("OnChatMessage failed: %s", err)
-		return
-	}
-
-	if resp.Succeed() {
-		for _, m := range messages {
-			log.Printf("OnChatMessage: g<%s>, u<%d>, d<%d>, t<%s>, ct<%s>, c<%s>", m.Cgid, m.User, m.Date, m.Type, m.ContentType, m.Content)
-			svc.handleOneMessage(m)
-		}
-	} else {
-		log.Printf("OnChatMessage: <%s,%s>", resp.Result, resp.Message)
-	}
+	user atomic.Value
+	answer.Service
 }
 
 func (svc *TuringService) SetUser(user *xxc.User) {
-	if svc.Tuling.APIKey == "" || svc.Tuling.APIPath == "" {
+	if svc.Answerer == nil {
 		return
 	}
 	svc.user.Store(user)
 
 	if user != nil {
-		user.Client.Mux.HandleFunc("chat.message", svc.OnChatMessage)
+		router := bot.NewRouter()
+		svc.Register(router)
+		router.Attach(user)
 	}
 
 }
@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var ErrUnclosedQuote = errors.New("unclosed quote")
+
+// 按空白拆分命令参数，支持单引号、双引号和反斜杠转义：
+//
+//	deploy "prod cn" --force  =>  ["deploy", "prod cn", "--force"]
+func SplitArgs(s string) ([]string, error) {
+	var args []string
+	var b strings.Builder
+	var quote rune
+	inArg := false
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, ErrUnclosedQuote
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
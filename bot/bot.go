@@ -0,0 +1,380 @@
+// 基于 xxc.User 的机器人命令框架
+//
+// 收到 @ 机器人的群消息或者私聊消息后，以 Prefix 开头的消息作为命令处理，如：
+//
+//	@bot /deploy prod
+//
+// 其他消息交给 Fallback 处理，回复发送到消息所在的会话
+package bot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xjdrew/xxc"
+)
+
+// 消息是否发给了 user：私聊消息，或者 @ 了 user 的群消息
+// 返回去掉 @ 后的文本；还没有收到会话信息的消息不处理
+func Addressed(user *xxc.User, message *xxc.ChatMessage) (string, bool) {
+	return addressed(user, message, false)
+}
+
+// trustUnknown 为 true 时，不在缓存中的会话当作私聊处理，不检查成员和 @
+func addressed(user *xxc.User, message *xxc.ChatMessage, trustUnknown bool) (string, bool) {
+	// 不处理非文本信息
+	if message.ContentType != xxc.ContentTypeText {
+		return "", false
+	}
+
+	profile := user.GetProfile()
+	if message.User == profile.Id {
+		return "", false
+	}
+
+	group := user.GetGroup(message.Cgid)
+	if group == nil {
+		if !trustUnknown {
+			// 加强校验，如果创建组的信息未到达，放弃处理
+			return "", false
+		}
+		text := user.StripMentions(message, profile.Id)
+		return text, text != ""
+	}
+
+	// 喧喧bug 1: 可能收到其他用户的one2one聊天信息
+	// 喧喧bug 2: 任何人都可以向其他用户的one2one会话里投递信息
+	if !group.IsInGroup(message.User) || !group.IsInGroup(profile.Id) {
+		return "", false
+	}
+
+	if group.Type != "one2one" && !user.MentionsMe(message) {
+		return "", false
+	}
+
+	text := user.StripMentions(message, profile.Id)
+	return text, text != ""
+}
+
+// 一次命令调用
+type Context struct {
+	User    *xxc.User
+	Message *xxc.ChatMessage
+	Sender  *xxc.UserProfile // 发送者，用户列表中找不到时为 nil
+	Text    string           // 去掉 @ 后的消息文本
+	Command *Command         // Fallback 中为 nil
+	Args    []string         // 命令参数，不包括命令名
+}
+
+// 回复到消息所在的会话
+func (c *Context) Reply(content string) error {
+	return c.User.SayToGroup(c.Message.Cgid, content)
+}
+
+func (c *Context) Replyf(format string, a ...interface{}) error {
+	return c.Reply(fmt.Sprintf(format, a...))
+}
+
+// 第 i 个参数，不存在时返回空字符串
+func (c *Context) Arg(i int) string {
+	if i < len(c.Args) {
+		return c.Args[i]
+	}
+	return ""
+}
+
+type HandlerFunc func(c *Context) error
+
+type Command struct {
+	Name    string
+	Aliases []string
+	Args    string // 参数说明，用于帮助，如 "<env> [version]"
+	Help    string // 一行说明
+	MinArgs int
+	MaxArgs int // < 0 表示不限
+
+	// 允许使用命令的账号和部门(包括下级部门)，都为空时所有人可以使用
+	Accounts []string
+	Depts    []string
+
+	Handler HandlerFunc
+}
+
+func (cmd *Command) usage(prefix string) string {
+	s := prefix + cmd.Name
+	if cmd.Args != "" {
+		s += " " + cmd.Args
+	}
+	return s
+}
+
+// 命令路由
+type Router struct {
+	Prefix   string      // 命令前缀，默认 "/"
+	Fallback HandlerFunc // 处理不是命令的消息和未知命令，可以为空
+
+	Workers   int // 同时处理的消息数，默认 8
+	QueueSize int // 每个会话排队等待处理（还没有开始处理）的消息数，超过后丢弃，默认 16
+
+	// 不在缓存中的会话当作私聊处理，不检查成员和 @，见 Addressed
+	TrustUnknownGroups bool
+
+	commands map[string]*Command // 包括别名
+	names    []string
+
+	mu     sync.Mutex
+	queues map[string][]*Context // 每个会话等待处理的消息
+	sem    chan struct{}
+}
+
+func (r *Router) workers() int {
+	if r.Workers <= 0 {
+		return 8
+	}
+	return r.Workers
+}
+
+func (r *Router) queueSize() int {
+	if r.QueueSize <= 0 {
+		return 16
+	}
+	return r.QueueSize
+}
+
+func (r *Router) prefix() string {
+	if r.Prefix == "" {
+		return "/"
+	}
+	return r.Prefix
+}
+
+// 注册命令，同名命令会被替换
+func (r *Router) Handle(cmd *Command) {
+	if r.commands == nil {
+		r.commands = make(map[string]*Command)
+	}
+	if _, ok := r.commands[cmd.Name]; !ok {
+		r.names = append(r.names, cmd.Name)
+		sort.Strings(r.names)
+	}
+	r.commands[cmd.Name] = cmd
+	for _, alias := range cmd.Aliases {
+		r.commands[alias] = cmd
+	}
+}
+
+// 注册不限参数、所有人可以使用的命令
+func (r *Router) HandleFunc(name string, help string, handler HandlerFunc) {
+	r.Handle(&Command{
+		Name:    name,
+		Help:    help,
+		MaxArgs: -1,
+		Handler: handler,
+	})
+}
+
+// 用户的账号在 accounts 中，或者所在部门(包括上级部门)在 depts 中，都不区分大小写
+func matchUser(user *xxc.User, sender *xxc.UserProfile, accounts []string, depts []string) bool {
+	for _, account := range accounts {
+		if strings.EqualFold(account, sender.Account) {
+			return true
+		}
+	}
+
+	if len(depts) == 0 {
+		return false
+	}
+	if len(user.GetDepts()) == 0 {
+		user.ReloadDeptList()
+	}
+	for _, dept := range user.DeptPath(sender.Dept) {
+		for _, name := range depts {
+			if strings.EqualFold(dept.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 用户是否可以使用命令
+func (r *Router) Allowed(user *xxc.User, sender *xxc.UserProfile, cmd *Command) bool {
+	if len(cmd.Accounts) == 0 && len(cmd.Depts) == 0 {
+		return true
+	}
+	return sender != nil && matchUser(user, sender, cmd.Accounts, cmd.Depts)
+}
+
+// markdown 格式的帮助文本，只列出 sender 可以使用的命令
+func (r *Router) Help(user *xxc.User, sender *xxc.UserProfile) string {
+	md := xxc.NewMarkdown()
+	md.Text("可用命令：").Line()
+	var lines []string
+	for _, name := range r.names {
+		cmd := r.commands[name]
+		if !r.Allowed(user, sender, cmd) {
+			continue
+		}
+		line := "`" + cmd.usage(r.prefix()) + "`"
+		if cmd.Help != "" {
+			line += " " + cmd.Help
+		}
+		if len(cmd.Aliases) > 0 {
+			line += "（别名：" + strings.Join(cmd.Aliases, ", ") + "）"
+		}
+		lines = append(lines, line)
+	}
+	md.List(lines...)
+	return md.Content()
+}
+
+func (r *Router) help(c *Context) error {
+	if name := strings.TrimPrefix(c.Arg(0), r.prefix()); name != "" {
+		cmd := r.commands[name]
+		if cmd == nil || !r.Allowed(c.User, c.Sender, cmd) {
+			return c.Replyf("未知命令 %s%s", r.prefix(), name)
+		}
+		return c.Replyf("用法：%s\n%s", cmd.usage(r.prefix()), cmd.Help)
+	}
+	return c.Reply(r.Help(c.User, c.Sender))
+}
+
+func (r *Router) dispatch(c *Context) error {
+	if !strings.HasPrefix(c.Text, r.prefix()) {
+		if r.Fallback == nil {
+			return nil
+		}
+		return r.Fallback(c)
+	}
+
+	args, err := SplitArgs(strings.TrimPrefix(c.Text, r.prefix()))
+	if err != nil {
+		return c.Replyf("命令格式错误：%s", err)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	name := args[0]
+	cmd := r.commands[name]
+	if cmd == nil {
+		if r.Fallback != nil {
+			return r.Fallback(c)
+		}
+		return c.Replyf("未知命令 %s%s，发送 %shelp 查看可用命令", r.prefix(), name, r.prefix())
+	}
+	if !r.Allowed(c.User, c.Sender, cmd) {
+		return c.Replyf("没有权限执行 %s%s", r.prefix(), cmd.Name)
+	}
+
+	c.Command = cmd
+	c.Args = args[1:]
+	if len(c.Args) < cmd.MinArgs || (cmd.MaxArgs >= 0 && len(c.Args) > cmd.MaxArgs) {
+		return c.Replyf("用法：%s", cmd.usage(r.prefix()))
+	}
+	return cmd.Handler(c)
+}
+
+func (r *Router) newContext(user *xxc.User, message *xxc.ChatMessage) *Context {
+	text, ok := addressed(user, message, r.TrustUnknownGroups)
+	if !ok {
+		return nil
+	}
+	return &Context{
+		User:    user,
+		Message: message,
+		Sender:  user.GetUserById(message.User),
+		Text:    text,
+	}
+}
+
+func (r *Router) handle(c *Context) {
+	if err := r.dispatch(c); err != nil {
+		log.Printf("handle %q failed: %s", c.Text, err)
+		if c.Command != nil {
+			c.Replyf("执行 %s%s 失败：%s", r.prefix(), c.Command.Name, err)
+		}
+	}
+}
+
+// 处理一条消息，不是发给 user 的消息会被忽略
+func (r *Router) HandleMessage(user *xxc.User, message *xxc.ChatMessage) {
+	if c := r.newContext(user, message); c != nil {
+		r.handle(c)
+	}
+}
+
+func (r *Router) initLocked() {
+	if r.queues == nil {
+		r.queues = make(map[string][]*Context)
+		r.sem = make(chan struct{}, r.workers())
+	}
+}
+
+// 把消息放入会话的队列，会话没有在处理的消息时启动 goroutine 处理
+func (r *Router) enqueue(c *Context) {
+	gid := c.Message.Cgid
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.initLocked()
+	q, running := r.queues[gid]
+	if len(q) >= r.queueSize() {
+		log.Printf("group %s: too many pending messages, drop %q", gid, c.Text)
+		return
+	}
+	r.queues[gid] = append(q, c)
+	if !running {
+		go r.work(gid)
+	}
+}
+
+// 按顺序处理一个会话的消息，队列为空时退出
+func (r *Router) work(gid string) {
+	for {
+		r.sem <- struct{}{}
+		r.mu.Lock()
+		q := r.queues[gid]
+		if len(q) == 0 {
+			delete(r.queues, gid)
+			r.mu.Unlock()
+			<-r.sem
+			return
+		}
+		c := q[0]
+		r.queues[gid] = q[1:]
+		r.mu.Unlock()
+
+		r.handle(c)
+		<-r.sem
+	}
+}
+
+// 处理 user 收到的消息
+// 消息处理函数在接收消息的 goroutine 中调用，命令中可能需要等待服务器返回，所以放到队列中处理：
+// 同一个会话的消息按顺序处理，不同会话最多同时处理 Workers 条，排队的消息超过 QueueSize 时丢弃
+func (r *Router) Attach(user *xxc.User) {
+	user.HandleMessage(func(message *xxc.ChatMessage) {
+		if c := r.newContext(user, message); c != nil {
+			r.enqueue(c)
+		}
+	})
+}
+
+// 创建命令路由，内置 help 命令
+func NewRouter() *Router {
+	r := &Router{}
+	r.Handle(&Command{
+		Name:    "help",
+		Aliases: []string{"帮助"},
+		Args:    "[command]",
+		Help:    "查看可用命令或命令的用法",
+		MaxArgs: 1,
+		Handler: func(c *Context) error { return r.help(c) },
+	})
+	return r
+}
@@ -0,0 +1,221 @@
+package bot
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xjdrew/xxc"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  deploy  prod ", []string{"deploy", "prod"}},
+		{`deploy "prod cn" --force`, []string{"deploy", "prod cn", "--force"}},
+		{`say 'a "b"'`, []string{"say", `a "b"`}},
+		{`say a\ b \"c\"`, []string{"say", "a b", `"c"`}},
+		{`say 'a\b'`, []string{"say", `a\b`}},
+		{`say ""`, []string{"say", ""}},
+	}
+	for _, c := range cases {
+		got, err := SplitArgs(c.in)
+		if err != nil {
+			t.Errorf("SplitArgs(%q) failed: %s", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+
+	for _, in := range []string{`say "a`, `say 'a`, `say a\`} {
+		if _, err := SplitArgs(in); err != ErrUnclosedQuote {
+			t.Errorf("SplitArgs(%q) error = %v, want %v", in, err, ErrUnclosedQuote)
+		}
+	}
+}
+
+func testFrame(direction string, frame string) *xxc.TraceFrame {
+	return &xxc.TraceFrame{Direction: direction, Frame: json.RawMessage(frame)}
+}
+
+// 通过回放登录的用户，会话 1&2 是和 alice 的私聊，g1 是群聊
+func testUser(t *testing.T) *xxc.User {
+	t.Helper()
+
+	transport := xxc.NewReplayTransport([]*xxc.TraceFrame{
+		testFrame(xxc.TraceOut, `{"userID":0,"module":"chat","method":"login","params":["","bot","******","online"],"data":null}`),
+		testFrame(xxc.TraceIn, `{"module":"chat","method":"login","result":"success","data":{"id":1,"account":"bot","realname":"Bot"}}`),
+		testFrame(xxc.TraceIn, `{"module":"chat","method":"usergetlist","result":"success","data":[`+
+			`{"id":1,"account":"bot","realname":"Bot"},{"id":2,"account":"alice","realname":"Alice"},{"id":3,"account":"carol","realname":"Carol"}]}`),
+		testFrame(xxc.TraceIn, `{"module":"chat","method":"getlist","result":"success","data":[`+
+			`{"gid":"1&2","type":"one2one","members":[1,2]},`+
+			`{"gid":"g1","name":"dev","type":"group","members":[1,2]}]}`),
+	})
+	t.Cleanup(func() { transport.Close() })
+
+	client := xxc.NewClientWithTransport(&xxc.ClientConfig{User: "bot", Password: "bot"}, transport)
+	user, err := xxc.CreateUserWithOptions(client, &xxc.UserOptions{})
+	if err != nil {
+		t.Fatalf("create user failed: %s", err)
+	}
+	return user
+}
+
+func testMessage(cgid string, from int, content string) *xxc.ChatMessage {
+	return &xxc.ChatMessage{
+		Gid:         "m",
+		Cgid:        cgid,
+		User:        from,
+		Type:        "normal",
+		ContentType: xxc.ContentTypeText,
+		Content:     content,
+	}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	user := testUser(t)
+
+	var calls []string
+	r := NewRouter()
+	r.Handle(&Command{
+		Name:    "echo",
+		MinArgs: 1,
+		MaxArgs: -1,
+		Handler: func(c *Context) error {
+			calls = append(calls, "echo "+c.Sender.Account+" "+c.Arg(0)+"|"+c.Arg(1))
+			return nil
+		},
+	})
+	r.Fallback = func(c *Context) error {
+		calls = append(calls, "fallback "+c.Text)
+		return nil
+	}
+
+	messages := []*xxc.ChatMessage{
+		testMessage("1&2", 2, `/echo a "b c"`),
+		testMessage("1&2", 2, "/unknown x"),
+		testMessage("g1", 2, "@Bot hello"),
+		testMessage("g1", 2, "not for bot"),     // 群里没有 @
+		testMessage("g1", 3, "@Bot not member"), // 不是群成员
+		testMessage("1&2", 1, "myself"),
+		testMessage("1&3", 3, "unknown group"),
+	}
+	for _, m := range messages {
+		r.HandleMessage(user, m)
+	}
+
+	want := []string{"echo alice a|b c", "fallback /unknown x", "fallback hello"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	// 信任不在缓存中的会话
+	calls = nil
+	r.TrustUnknownGroups = true
+	r.HandleMessage(user, testMessage("1&3", 3, "@Bot unknown group"))
+	if want := []string{"fallback unknown group"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestRouterAllowed(t *testing.T) {
+	user := testUser(t)
+	r := NewRouter()
+	cmd := &Command{Name: "admin", Accounts: []string{"ALICE"}}
+
+	if !r.Allowed(user, user.GetUserByAccount("alice"), cmd) {
+		t.Errorf("alice should be allowed")
+	}
+	if r.Allowed(user, user.GetUserByAccount("carol"), cmd) {
+		t.Errorf("carol should not be allowed")
+	}
+	if r.Allowed(user, nil, cmd) {
+		t.Errorf("unknown sender should not be allowed")
+	}
+}
+
+func TestRouterQueue(t *testing.T) {
+	user := testUser(t)
+
+	var mu sync.Mutex
+	var got []string
+	running, maxRunning := 0, 0
+	done := make(chan struct{}, 16)
+
+	r := NewRouter()
+	r.Workers = 2
+	r.QueueSize = 3
+	r.Fallback = func(c *Context) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		got = append(got, c.Message.Cgid+":"+c.Text)
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	}
+
+	// 占满所有 worker，消息都留在队列中；每个会话最多排队 3 条，多出的丢弃
+	r.mu.Lock()
+	r.initLocked()
+	r.mu.Unlock()
+	r.sem <- struct{}{}
+	r.sem <- struct{}{}
+	for _, text := range []string{"1", "2", "3", "4"} {
+		r.enqueue(r.newContext(user, testMessage("1&2", 2, text)))
+	}
+	for _, text := range []string{"a", "b"} {
+		r.enqueue(r.newContext(user, testMessage("g1", 2, "@Bot "+text)))
+	}
+	<-r.sem
+	<-r.sem
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d messages handled", i)
+		}
+	}
+
+	select {
+	case <-done:
+		t.Fatalf("dropped message handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning > 2 {
+		t.Errorf("max running = %d, want <= 2", maxRunning)
+	}
+	var one2one, group []string
+	for _, s := range got {
+		if s[:2] == "g1" {
+			group = append(group, s)
+		} else {
+			one2one = append(one2one, s)
+		}
+	}
+	if want := []string{"1&2:1", "1&2:2", "1&2:3"}; !reflect.DeepEqual(one2one, want) {
+		t.Errorf("one2one = %q, want %q", one2one, want)
+	}
+	if want := []string{"g1:a", "g1:b"}; !reflect.DeepEqual(group, want) {
+		t.Errorf("group = %q, want %q", group, want)
+	}
+}
@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xjdrew/xxc"
+)
+
+type usage struct {
+	start time.Time // 当前周期的开始时间
+	count int
+	last  time.Time // 最后一次提问的时间
+}
+
+// 每个周期的次数限制，返回需要等待的时间
+func (u *usage) wait(now time.Time, quota int, period time.Duration) time.Duration {
+	if now.Sub(u.start) >= period {
+		u.start = now
+		u.count = 0
+	}
+	if quota > 0 && u.count >= quota {
+		return u.start.Add(period).Sub(now)
+	}
+	return 0
+}
+
+// 防止滥用机器人：黑白名单、提问配额和冷却时间
+type Guard struct {
+	UserQuota  int           // 每个用户每个周期最多提问的次数，0 表示不限
+	GroupQuota int           // 每个群每个周期最多提问的次数，0 表示不限
+	Period     time.Duration // 配额周期，默认 1 小时
+	Cooldown   time.Duration // 同一用户两次提问的最小间隔
+	Allow      []string      // 账号或部门名称，不为空时只回答这些用户
+	Deny       []string      // 账号或部门名称，不回答这些用户
+
+	ThrottleReply string // 提问超过限制时的回复，为空时不回复
+
+	mu       sync.Mutex
+	usages   map[string]*usage    // 用户和群的提问记录
+	notified map[string]time.Time // 最后一次提醒用户被限制的时间，避免提醒本身刷屏
+}
+
+func (g *Guard) period() time.Duration {
+	if g.Period <= 0 {
+		return time.Hour
+	}
+	return g.Period
+}
+
+// 逗号分隔的列表，忽略空项
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// 注册限制相关的命令行参数
+func (g *Guard) AddFlags(fs *flag.FlagSet) {
+	fs.IntVar(&g.UserQuota, "userQuota", 30, "max questions per user per quota period, 0 means unlimited")
+	fs.IntVar(&g.GroupQuota, "groupQuota", 100, "max questions per group per quota period, 0 means unlimited")
+	fs.DurationVar(&g.Period, "quotaPeriod", time.Hour, "quota period")
+	fs.DurationVar(&g.Cooldown, "cooldown", 3*time.Second, "min interval between questions from one user")
+	fs.Func("allow", "comma separated accounts or depts allowed to ask, empty means everyone", func(s string) error {
+		g.Allow = splitList(s)
+		return nil
+	})
+	fs.Func("deny", "comma separated accounts or depts not allowed to ask", func(s string) error {
+		g.Deny = splitList(s)
+		return nil
+	})
+	fs.StringVar(&g.ThrottleReply, "throttleReply", "你问得太快了，休息一下吧", "reply when a user asks too often, empty means no reply")
+}
+
+// 是否回答用户的问题
+func (g *Guard) Permitted(user *xxc.User, sender *xxc.UserProfile) bool {
+	if len(g.Allow) == 0 && len(g.Deny) == 0 {
+		return true
+	}
+	if sender == nil {
+		return false
+	}
+	if len(g.Deny) > 0 && matchUser(user, sender, g.Deny, g.Deny) {
+		return false
+	}
+	return len(g.Allow) == 0 || matchUser(user, sender, g.Allow, g.Allow)
+}
+
+func (g *Guard) usage(key string, now time.Time) *usage {
+	u := g.usages[key]
+	if u == nil {
+		u = &usage{start: now}
+		g.usages[key] = u
+	}
+	return u
+}
+
+// 清理不再影响限制的记录，避免无限增长
+func (g *Guard) prune(now time.Time) {
+	keep := g.period()
+	if g.Cooldown > keep {
+		keep = g.Cooldown
+	}
+	for k, u := range g.usages {
+		if now.Sub(u.last) > keep && now.Sub(u.start) > keep {
+			delete(g.usages, k)
+			delete(g.notified, k)
+		}
+	}
+}
+
+// 记录一次提问，超过限制时返回需要等待的时间，不记录
+// notify 表示是否应该提醒用户，同一用户每分钟最多提醒一次
+func (g *Guard) Take(userID int, gid string, isGroup bool) (wait time.Duration, notify bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if g.usages == nil {
+		g.usages = make(map[string]*usage)
+		g.notified = make(map[string]time.Time)
+	}
+	if len(g.usages) >= 4096 {
+		g.prune(now)
+	}
+
+	userKey := fmt.Sprintf("u/%d", userID)
+	u := g.usage(userKey, now)
+	wait = u.wait(now, g.UserQuota, g.period())
+	if d := u.last.Add(g.Cooldown).Sub(now); d > wait {
+		wait = d
+	}
+
+	var gu *usage
+	if isGroup {
+		gu = g.usage("g/"+gid, now)
+		if d := gu.wait(now, g.GroupQuota, g.period()); d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		if now.Sub(g.notified[userKey]) >= time.Minute {
+			g.notified[userKey] = now
+			notify = true
+		}
+		return wait, notify
+	}
+
+	u.count++
+	u.last = now
+	if gu != nil {
+		gu.count++
+		gu.last = now
+	}
+	return 0, false
+}
+
+// 退还 Take 记录的一次提问，用于后端出错没有回答的情况；冷却时间不退还
+func (g *Guard) Refund(userID int, gid string, isGroup bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if u := g.usages[fmt.Sprintf("u/%d", userID)]; u != nil && u.count > 0 {
+		u.count--
+	}
+	if isGroup {
+		if u := g.usages["g/"+gid]; u != nil && u.count > 0 {
+			u.count--
+		}
+	}
+}
+
+func isGroupMessage(c *Context) bool {
+	group := c.User.GetGroup(c.Message.Cgid)
+	return group != nil && group.Type != "one2one"
+}
+
+// 是否回答 c 中的问题，回答时记录一次提问；超过限制时按 ThrottleReply 回复用户
+func (g *Guard) Admit(c *Context) bool {
+	if !g.Permitted(c.User, c.Sender) {
+		log.Printf("\tdenied u<%d>", c.Message.User)
+		return false
+	}
+
+	wait, notify := g.Take(c.Message.User, c.Message.Cgid, isGroupMessage(c))
+	if wait <= 0 {
+		return true
+	}
+	log.Printf("\tthrottled u<%d> for %s", c.Message.User, wait)
+	if notify && g.ThrottleReply != "" {
+		if wait < time.Second {
+			wait = time.Second
+		}
+		if err := c.Replyf("%s（%s 后可以再问）", g.ThrottleReply, wait.Round(time.Second)); err != nil {
+			log.Printf("\treply failed: %s", err)
+		}
+	}
+	return false
+}
+
+// 退还 Admit 记录的一次提问
+func (g *Guard) Release(c *Context) {
+	g.Refund(c.Message.User, c.Message.Cgid, isGroupMessage(c))
+}
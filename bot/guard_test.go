@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGuardQuota(t *testing.T) {
+	g := &Guard{UserQuota: 2, GroupQuota: 2, Period: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if wait, _ := g.Take(1, "g1", true); wait != 0 {
+			t.Fatalf("take %d: wait = %s", i, wait)
+		}
+	}
+	wait, notify := g.Take(1, "g1", true)
+	if wait <= 0 || !notify {
+		t.Fatalf("user quota: wait = %s, notify = %v", wait, notify)
+	}
+	// 一分钟内只提醒一次
+	if _, notify := g.Take(1, "g1", true); notify {
+		t.Errorf("notified twice")
+	}
+
+	// 后端出错时退还配额
+	g.Refund(1, "g1", true)
+	if wait, _ := g.Take(1, "g1", true); wait != 0 {
+		t.Errorf("after refund: wait = %s", wait)
+	}
+
+	// 群的配额由所有人共享
+	if wait, _ := g.Take(2, "g1", true); wait <= 0 {
+		t.Errorf("group quota: wait = %s", wait)
+	}
+	if wait, _ := g.Take(2, "1&2", false); wait != 0 {
+		t.Errorf("one2one: wait = %s", wait)
+	}
+}
+
+func TestGuardCooldown(t *testing.T) {
+	g := &Guard{Cooldown: time.Hour}
+	if wait, _ := g.Take(1, "1&2", false); wait != 0 {
+		t.Fatalf("first take: wait = %s", wait)
+	}
+	// 冷却时间不退还
+	g.Refund(1, "1&2", false)
+	if wait, _ := g.Take(1, "1&2", false); wait <= 0 {
+		t.Errorf("cooldown: wait = %s", wait)
+	}
+}
+
+func TestGuardPermitted(t *testing.T) {
+	user := testUser(t)
+	alice := user.GetUserByAccount("alice")
+
+	if !(&Guard{}).Permitted(user, nil) {
+		t.Errorf("empty guard should permit everyone")
+	}
+	if (&Guard{Allow: []string{"alice"}}).Permitted(user, nil) {
+		t.Errorf("unknown sender should not be permitted")
+	}
+	if !(&Guard{Allow: []string{"ALICE"}}).Permitted(user, alice) {
+		t.Errorf("alice should be permitted")
+	}
+	if (&Guard{Deny: []string{"Alice"}}).Permitted(user, alice) {
+		t.Errorf("alice should be denied")
+	}
+}
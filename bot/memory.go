@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xjdrew/xxc"
+)
+
+// 一问一答
+type Exchange struct {
+	Question string
+	Answer   string
+	Time     time.Time
+}
+
+// 一段对话
+type Conversation struct {
+	Key       string
+	Session   int        // 每次超时重置后加 1
+	Exchanges []Exchange // 最近的问答，按时间顺序
+}
+
+// 对话 id，重置后变化，可以作为后端记录上下文的用户 id
+// 32 位的字母和数字
+func (c *Conversation) ID() string {
+	h := md5.Sum([]byte(fmt.Sprintf("%s#%d", c.Key, c.Session)))
+	return hex.EncodeToString(h[:])
+}
+
+type memoryEntry struct {
+	conversation *Conversation
+	last         time.Time
+}
+
+// 记住每个用户或每个群最近的对话，长时间没有对话后重新开始
+type Memory struct {
+	Size     int           // 记住最近多少轮问答，默认 5
+	Timeout  time.Duration // 多久没有对话后重新开始，默认 10 分钟
+	PerGroup bool          // 群聊中所有人共享一段对话，否则每个人单独一段
+
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+func (m *Memory) AddFlags(fs *flag.FlagSet) {
+	fs.IntVar(&m.Size, "memorySize", 5, "number of recent exchanges remembered per conversation")
+	fs.DurationVar(&m.Timeout, "memoryTimeout", 10*time.Minute, "start a new conversation after this long without messages")
+	fs.BoolVar(&m.PerGroup, "memoryPerGroup", false, "share one conversation among all members of a group")
+}
+
+func (m *Memory) size() int {
+	if m.Size <= 0 {
+		return 5
+	}
+	return m.Size
+}
+
+func (m *Memory) timeout() time.Duration {
+	if m.Timeout <= 0 {
+		return 10 * time.Minute
+	}
+	return m.Timeout
+}
+
+// 消息所属对话的 key
+func (m *Memory) Key(message *xxc.ChatMessage) string {
+	if m.PerGroup {
+		return message.Cgid
+	}
+	return fmt.Sprintf("%s/%d", message.Cgid, message.User)
+}
+
+func (m *Memory) entry(key string, now time.Time) *memoryEntry {
+	if m.entries == nil {
+		m.entries = make(map[string]*memoryEntry)
+	}
+
+	e := m.entries[key]
+	if e == nil {
+		// 清理过期的对话，避免无限增长
+		if len(m.entries) >= 1024 {
+			for k, v := range m.entries {
+				if now.Sub(v.last) > m.timeout() {
+					delete(m.entries, k)
+				}
+			}
+		}
+		e = &memoryEntry{conversation: &Conversation{Key: key}, last: now}
+		m.entries[key] = e
+	} else if now.Sub(e.last) > m.timeout() {
+		e.conversation.Session++
+		e.conversation.Exchanges = nil
+		e.last = now
+	}
+	return e
+}
+
+// 当前的对话，返回的是副本
+func (m *Memory) Get(key string) *Conversation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *m.entry(key, time.Now()).conversation
+	c.Exchanges = append([]Exchange(nil), c.Exchanges...)
+	return &c
+}
+
+// 记录一轮问答
+func (m *Memory) Record(key string, question string, answer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	e := m.entry(key, now)
+	e.last = now
+
+	c := e.conversation
+	c.Exchanges = append(c.Exchanges, Exchange{
+		Question: question,
+		Answer:   answer,
+		Time:     now,
+	})
+	if n := len(c.Exchanges) - m.size(); n > 0 {
+		c.Exchanges = append([]Exchange(nil), c.Exchanges[n:]...)
+	}
+}
+
+// 忘记之前的对话，重新开始
+func (m *Memory) Reset(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e := m.entries[key]; e != nil {
+		e.conversation.Session++
+		e.conversation.Exchanges = nil
+	}
+}
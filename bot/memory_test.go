@@ -0,0 +1,43 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryTimeout(t *testing.T) {
+	m := &Memory{Size: 2, Timeout: 10 * time.Minute}
+	now := time.Now()
+
+	e := m.entry("k", now)
+	e.conversation.Exchanges = []Exchange{{Question: "q1"}, {Question: "q2"}}
+
+	// 超时后开始新的对话
+	e = m.entry("k", now.Add(11*time.Minute))
+	if e.conversation.Session != 1 || len(e.conversation.Exchanges) != 0 {
+		t.Fatalf("conversation = %+v, want session 1 without exchanges", e.conversation)
+	}
+
+	// 新对话从重置的时间开始计算超时
+	e = m.entry("k", now.Add(12*time.Minute))
+	if e.conversation.Session != 1 {
+		t.Errorf("session = %d, want 1", e.conversation.Session)
+	}
+}
+
+func TestMemoryRecord(t *testing.T) {
+	m := &Memory{Size: 2}
+	for _, q := range []string{"q1", "q2", "q3"} {
+		m.Record("k", q, "a")
+	}
+	c := m.Get("k")
+	if len(c.Exchanges) != 2 || c.Exchanges[0].Question != "q2" || c.Exchanges[1].Question != "q3" {
+		t.Errorf("exchanges = %+v", c.Exchanges)
+	}
+
+	id := c.ID()
+	m.Reset("k")
+	if c := m.Get("k"); c.ID() == id || len(c.Exchanges) != 0 {
+		t.Errorf("conversation not reset: %+v", c)
+	}
+}
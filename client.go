@@ -1,8 +1,10 @@
 package xxc
 
 import (
+	"encoding/json"
 	"log"
 	"sync"
+	"time"
 )
 
 var Verbose = false
@@ -10,7 +12,15 @@ var Verbose = false
 type ClientConfig struct {
 	Host     string
 	User     string
-	Password string
+	Password string  // 明文密码，创建客户端时计算 md5，Client 不保留明文
+	Trace    string  // 协议跟踪文件，记录所有解密后的帧，为空时不记录
+	Metrics  Metrics // 客户端指标，可以为空
+
+	PasswordHash string // md5 后的密码，设置后忽略 Password
+	Token        string // 会话 token，代替密码发给服务器，设置后忽略 Password 和 PasswordHash
+
+	RateLimit   *RateLimitConfig // 请求限速，为空时不限制
+	CallTimeout time.Duration    // 等待服务器返回的时间，默认 30 秒
 }
 
 type ServerConfig struct {
@@ -20,14 +30,20 @@ type ServerConfig struct {
 	UploadFileSize int64  `json:"uploadFileSize"`
 	ChatPort       int    `json:"chatPort"`
 	TestModel      bool   `json:"testModel"`
+
+	Fields map[string]json.RawMessage `json:"-"` // serverInfo 返回的所有字段
 }
 
 type Client struct {
-	clientConfig *ClientConfig
+	clientConfig *ClientConfig // 创建时的副本，不含凭证
 	serverConfig *ServerConfig
+	credential   []byte // 登录凭证，登录后清零
 
 	httpClient *httpClient
 	wsClient   *wsClient
+	transport  Transport // 不为空时使用此传输层，不再连接服务器
+
+	limiter *rateLimiter
 
 	initMutex sync.Mutex
 	initErr   error
@@ -36,6 +52,9 @@ type Client struct {
 	loginErr   error
 	user       *UserProfile
 
+	featureMutex sync.Mutex
+	unsupported  map[string]bool // 探测到服务器不支持的功能，见 Supports
+
 	Mux *ClientMux
 }
 
@@ -46,11 +65,37 @@ func (c *Client) getMux() *ClientMux {
 	return c.Mux
 }
 
+func (c *Client) getMetrics() Metrics {
+	if c.clientConfig.Metrics == nil {
+		return nopMetrics{}
+	}
+	return c.clientConfig.Metrics
+}
+
 func (c *Client) initWsClient() error {
-	ws, err := createWsClient(c.clientConfig.Host, c.serverConfig.ChatPort, []byte(c.serverConfig.Token), c.getMux())
+	var tracer *Tracer
+	if c.clientConfig.Trace != "" {
+		var err error
+		tracer, err = OpenTracer(c.clientConfig.Trace)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.transport != nil {
+		c.wsClient = newWsClient(c.transport, c.getMux(), tracer, c.getMetrics(), c.probeFailure)
+		c.wsClient.callTimeout = c.clientConfig.CallTimeout
+		return nil
+	}
+
+	ws, err := createWsClient(c.clientConfig.Host, c.serverConfig.ChatPort, []byte(c.serverConfig.Token), c.getMux(), tracer, c.getMetrics(), c.probeFailure)
 	if err != nil {
+		if tracer != nil {
+			tracer.Close()
+		}
 		return err
 	}
+	ws.callTimeout = c.clientConfig.CallTimeout
 	c.wsClient = ws
 	return nil
 }
@@ -60,16 +105,10 @@ func (c *Client) initWithLocked() error {
 		log.Printf("init start")
 	}
 
-	serverConfigReq := &Request{
-		Module: "chat",
-		Method: "login",
-		Params: []interface{}{
-			"",
-			c.clientConfig.User,
-			hashPassword(c.clientConfig.Password),
-			"online",
-		},
-	}
+	serverConfigReq := NewChatRequest(0, &LoginAPI{
+		Account:  c.clientConfig.User,
+		Password: string(c.credential),
+	})
 
 	serverConfig := &ServerConfig{}
 
@@ -109,24 +148,18 @@ func (c *Client) loginWithLocked() error {
 		return err
 	}
 
-	loginReq := &Request{
-		Module: "chat",
-		Method: "login",
-		Params: []interface{}{
-			"",
-			c.clientConfig.User,
-			hashPassword(c.clientConfig.Password),
-			"online",
-		},
+	loginAPI := &LoginAPI{
+		Account:  c.clientConfig.User,
+		Password: string(c.credential),
 	}
 
-	resp, err := c.Call(loginReq)
+	resp, err := c.Call(NewChatRequest(0, loginAPI))
 	if err != nil {
 		return err
 	}
 
-	profile := &UserProfile{}
-	if err := resp.ConvertDataTo(profile); err != nil {
+	profile, err := loginAPI.Decode(resp)
+	if err != nil {
 		return err
 	}
 
@@ -154,7 +187,12 @@ func (c *Client) Login() error {
 		return c.loginErr
 	}
 
-	if err := c.loginWithLocked(); err != nil {
+	// 无论成功与否都只登录一次，登录后不再需要凭证
+	err := c.loginWithLocked()
+	for i := range c.credential {
+		c.credential[i] = 0
+	}
+	if err != nil {
 		c.loginErr = err
 		return err
 	}
@@ -170,11 +208,7 @@ func (c *Client) Logout() error {
 		return nil
 	}
 
-	logoutReq := &Request{
-		UserID: c.user.Id,
-		Module: "chat",
-		Method: "logout",
-	}
+	logoutReq := NewChatRequest(c.user.Id, &LogoutAPI{})
 
 	_, err := c.Call(logoutReq)
 	if err != nil {
@@ -194,10 +228,18 @@ func (c *Client) GetUser() (*UserProfile, error) {
 }
 
 func (c *Client) Call(req *Request) (*Response, error) {
+	return c.callMatch(req, nil)
+}
+
+// 同一方法可以有多个等待中的调用，用 match 区分各自的返回，见 wsClient.call
+func (c *Client) callMatch(req *Request, match func(*Response) bool) (*Response, error) {
 	if Verbose {
 		log.Printf("+ call %s", req.MethodName())
 	}
-	resp, err := c.wsClient.Call(req)
+	if err := c.limiter.wait(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.wsClient.call(req, match)
 
 	if Verbose {
 		if err != nil {
@@ -213,19 +255,81 @@ func (c *Client) Send(req *Request) error {
 	if Verbose {
 		log.Printf("* send %s", req.MethodName())
 	}
+	if err := c.limiter.wait(req); err != nil {
+		return err
+	}
 	err := c.wsClient.Send(req)
 	return err
 }
 
+// 服务器是否支持某个功能，见 Feature 开头的常量
+// 服务器没有声明 features 时先认为支持，调用失败后记住不支持，同一个客户端不再尝试
+func (c *Client) Supports(feature string) bool {
+	if c.serverConfig != nil && !c.serverConfig.Supports(feature) {
+		return false
+	}
+
+	c.featureMutex.Lock()
+	defer c.featureMutex.Unlock()
+	return !c.unsupported[feature]
+}
+
+// 记录服务器不支持的功能
+func (c *Client) setUnsupported(feature string) {
+	c.featureMutex.Lock()
+	defer c.featureMutex.Unlock()
+
+	if c.unsupported[feature] {
+		return
+	}
+	log.Printf("server does not support %s", feature)
+	if c.unsupported == nil {
+		c.unsupported = make(map[string]bool)
+	}
+	c.unsupported[feature] = true
+}
+
+// 功能对应的方法返回失败时认为服务器不支持该功能
+// xxd 没有区分未知方法和其他错误的返回，所以不看 message
+func (c *Client) probeFailure(resp *Response) {
+	if feature, ok := featureMethods[resp.MethodName()]; ok {
+		c.setUnsupported(feature)
+	}
+}
+
+// 服务器版本，还没有初始化时 ok 为 false
+func (c *Client) ServerVersion() (v Version, ok bool) {
+	if c.serverConfig == nil {
+		return v, false
+	}
+	return c.serverConfig.ParsedVersion()
+}
+
 func (c *Client) HandleConnectionError(f func(error)) {
 	c.wsClient.OnHandleError = f
 }
 
+// 使用指定的传输层创建客户端，跳过 serverInfo 请求，用于回放等场景
+func NewClientWithTransport(config *ClientConfig, transport Transport) *Client {
+	c := NewClient(config)
+	c.serverConfig = &ServerConfig{}
+	c.transport = transport
+	return c
+}
+
+// 创建客户端，复制 config，不修改调用方的配置，同一个 config 可以用于多次登录
 func NewClient(config *ClientConfig) *Client {
 	if Verbose {
-		log.Printf("clientConfig: %+v", config)
+		log.Printf("clientConfig: host=%s user=%s", config.Host, config.User)
 	}
+	cfg := *config
+	credential := cfg.credential()
+	cfg.Password = ""
+	cfg.PasswordHash = ""
+	cfg.Token = ""
 	return &Client{
-		clientConfig: config,
+		clientConfig: &cfg,
+		credential:   credential,
+		limiter:      newRateLimiter(cfg.RateLimit),
 	}
 }
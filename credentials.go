@@ -0,0 +1,143 @@
+package xxc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// 登录凭证的环境变量
+const (
+	EnvPassword     = "XXC_PASSWORD"
+	EnvPasswordHash = "XXC_PASSWORD_HASH"
+	EnvToken        = "XXC_TOKEN"
+)
+
+var ErrNoCredentials = errors.New("no credentials, set password, passwordHash, token, a credentials file or $" + EnvPassword)
+
+// 设置明文密码，计算 md5 后把 password 清零
+func (c *ClientConfig) SetPassword(password []byte) {
+	c.PasswordHash = hashBytes(password)
+	c.Password = ""
+	for i := range password {
+		password[i] = 0
+	}
+}
+
+func (c *ClientConfig) hasCredentials() bool {
+	return c.Password != "" || c.PasswordHash != "" || c.Token != ""
+}
+
+// 登录时发给服务器的凭证，依次使用 Token、PasswordHash 和 Password
+func (c *ClientConfig) credential() []byte {
+	switch {
+	case c.Token != "":
+		return []byte(c.Token)
+	case c.PasswordHash != "":
+		return []byte(c.PasswordHash)
+	default:
+		return []byte(hashPassword(c.Password))
+	}
+}
+
+// 没有设置凭证时从环境变量读取
+// 多个账号可以共用环境变量，所以这里不删除；所有账号读取完后调用 UnsetCredentialsEnv
+func LoadCredentialsEnv(config *ClientConfig) {
+	if config.hasCredentials() {
+		return
+	}
+
+	if token := os.Getenv(EnvToken); token != "" {
+		config.Token = token
+	} else if hash := os.Getenv(EnvPasswordHash); hash != "" {
+		config.PasswordHash = hash
+	} else if password := os.Getenv(EnvPassword); password != "" {
+		config.PasswordHash = hashPassword(password)
+	}
+}
+
+// 删除凭证的环境变量，避免传给子进程
+func UnsetCredentialsEnv() {
+	os.Unsetenv(EnvToken)
+	os.Unsetenv(EnvPasswordHash)
+	os.Unsetenv(EnvPassword)
+}
+
+// 没有设置凭证时从文件读取，文件每行一项：
+//
+//	password=明文密码
+//	passwordHash=md5 后的密码
+//	token=会话 token
+//
+// 以 # 开头的行是注释；读取后文件内容从内存中清零
+func LoadCredentialsFile(config *ClientConfig, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for i := range data {
+			data[i] = 0
+		}
+	}()
+
+	if config.hasCredentials() {
+		return nil
+	}
+
+	var password, passwordHash, token []byte
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		sep := bytes.IndexByte(line, '=')
+		if sep == -1 {
+			return fmt.Errorf("%s:%d: invalid line", path, i+1)
+		}
+		value := bytes.TrimSpace(line[sep+1:])
+		switch key := string(bytes.TrimSpace(line[:sep])); key {
+		case "password":
+			password = value
+		case "passwordHash":
+			passwordHash = value
+		case "token":
+			token = value
+		default:
+			return fmt.Errorf("%s:%d: unknown key %q", path, i+1, key)
+		}
+	}
+
+	switch {
+	case len(token) > 0:
+		config.Token = string(token)
+	case len(passwordHash) > 0:
+		config.PasswordHash = string(passwordHash)
+	case len(password) > 0:
+		config.SetPassword(password)
+	}
+	return nil
+}
+
+// 依次从文件（path 不为空时）和环境变量读取还没有设置的凭证
+// 明文密码转换成 PasswordHash，不在 config 中保留
+func LoadCredentials(config *ClientConfig, path string) error {
+	if config.Password != "" && config.PasswordHash == "" {
+		config.PasswordHash = hashPassword(config.Password)
+	}
+	config.Password = ""
+
+	if path != "" {
+		if err := LoadCredentialsFile(config, path); err != nil {
+			return err
+		}
+	}
+	LoadCredentialsEnv(config)
+
+	if !config.hasCredentials() {
+		return ErrNoCredentials
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package xxc
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	// 明文密码转换成 PasswordHash
+	config := &ClientConfig{Password: "bot"}
+	if err := LoadCredentials(config, ""); err != nil {
+		t.Fatal(err)
+	}
+	if config.Password != "" || config.PasswordHash != hashPassword("bot") {
+		t.Errorf("config = %+v", config)
+	}
+
+	// 文件中 token 优先
+	path := filepath.Join(t.TempDir(), "credentials")
+	data := "# bot\npassword=bot\ntoken = abc\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config = &ClientConfig{}
+	if err := LoadCredentials(config, path); err != nil {
+		t.Fatal(err)
+	}
+	if config.Token != "abc" || string(config.credential()) != "abc" {
+		t.Errorf("config = %+v", config)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("secret=1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadCredentials(&ClientConfig{}, path); err == nil {
+		t.Errorf("unknown key should fail")
+	}
+}
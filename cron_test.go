@@ -0,0 +1,93 @@
+package xxc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"30 9 * * 1-5",
+		"*/15 0-23/2 1,15 jan-jun mon,fri",
+		"0 0 * * 7",
+		"@daily",
+		"@Hourly",
+	}
+	for _, spec := range valid {
+		if _, err := ParseCron(spec); err != nil {
+			t.Errorf("ParseCron(%q) failed: %s", spec, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"5/1 * * * *", // 步长只能用于 * 和范围
+		"5/15 * * * *",
+		"foo * * * *",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) should fail", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc := time.UTC
+	date := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+
+	cases := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		// 2026-10-19 是周一
+		{"* * * * *", date(2026, 10, 19, 9, 0).Add(30 * time.Second), date(2026, 10, 19, 9, 1)},
+		{"30 9 * * 1-5", date(2026, 10, 19, 9, 30), date(2026, 10, 20, 9, 30)},
+		{"30 9 * * 1-5", date(2026, 10, 23, 10, 0), date(2026, 10, 26, 9, 30)},
+		{"*/15 * * * *", date(2026, 10, 19, 9, 7), date(2026, 10, 19, 9, 15)},
+		{"0 10-18/4 * * *", date(2026, 10, 19, 11, 0), date(2026, 10, 19, 14, 0)},
+		{"@monthly", date(2026, 10, 19, 0, 0), date(2026, 11, 1, 0, 0)},
+		{"@yearly", date(2026, 10, 19, 0, 0), date(2027, 1, 1, 0, 0)},
+		{"0 0 * * 7", date(2026, 10, 19, 0, 0), date(2026, 10, 25, 0, 0)},
+		{"0 0 29 2 *", date(2026, 3, 1, 0, 0), date(2028, 2, 29, 0, 0)},
+		// 日和周都有限制时满足其中一个即可：1 号或者周五
+		{"0 0 1 * 5", date(2026, 10, 19, 0, 0), date(2026, 10, 23, 0, 0)},
+		{"0 0 1 * 5", date(2026, 10, 30, 1, 0), date(2026, 11, 1, 0, 0)},
+		// 以 * 开头的日或周算作 *，两个都要满足：奇数日并且是周五
+		{"0 0 */2 * 5", date(2026, 10, 19, 0, 0), date(2026, 10, 23, 0, 0)},
+		{"0 0 */2 * 5", date(2026, 10, 23, 1, 0), date(2026, 11, 13, 0, 0)},
+		{"0 0 1 * */2", date(2026, 10, 19, 0, 0), date(2026, 11, 1, 0, 0)},
+		{"0 0 1 * */2", date(2026, 11, 1, 0, 0), date(2026, 12, 1, 0, 0)},
+	}
+	for _, c := range cases {
+		s, err := ParseCron(c.spec)
+		if err != nil {
+			t.Errorf("ParseCron(%q) failed: %s", c.spec, err)
+			continue
+		}
+		if got := s.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", c.spec, c.from, got, c.want)
+		}
+	}
+
+	// 不会触发
+	s, err := ParseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(date(2026, 1, 1, 0, 0)); !got.IsZero() {
+		t.Errorf("Next = %s, want zero", got)
+	}
+}
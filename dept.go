@@ -0,0 +1,154 @@
+package xxc
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+type Dept struct {
+	Id     int    // 部门ID
+	Name   string // 部门名称
+	Parent int    // 上级部门ID，0 表示顶级部门
+	Order  int    // 排序
+}
+
+func (u *User) updateDepts(depts []*Dept) {
+	u.deptMutex.Lock()
+	defer u.deptMutex.Unlock()
+
+	u.depts = make(map[int]*Dept)
+	for _, dept := range depts {
+		u.depts[dept.Id] = dept
+	}
+}
+
+// 刷新部门列表
+func (u *User) OnChatDeptGetList(resp *Response) {
+	var depts []*Dept
+	err := resp.ConvertDataTo(&depts)
+	if err != nil {
+		log.Printf("OnChatDeptGetList failed: %s", err)
+		return
+	}
+	if Verbose {
+		log.Printf("OnChatDeptGetList: %d depts", len(depts))
+	}
+	u.updateDepts(depts)
+}
+
+// 从服务器重新拉取部门列表
+func (u *User) ReloadDeptList() []*Dept {
+	if !u.Client.Supports(FeatureDeptList) {
+		return u.GetDepts()
+	}
+
+	request := NewChatRequest(u.profile.Id, &DeptGetListAPI{})
+
+	response, err := u.Client.Call(request)
+	if err == nil {
+		u.OnChatDeptGetList(response)
+	}
+	return u.GetDepts()
+}
+
+// 所有部门，按 Order 排序
+func (u *User) GetDepts() []*Dept {
+	u.deptMutex.RLock()
+	defer u.deptMutex.RUnlock()
+
+	l := make([]*Dept, 0, len(u.depts))
+	for _, dept := range u.depts {
+		l = append(l, dept)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Order != l[j].Order {
+			return l[i].Order < l[j].Order
+		}
+		return l[i].Id < l[j].Id
+	})
+	return l
+}
+
+func (u *User) GetDept(id int) *Dept {
+	u.deptMutex.RLock()
+	defer u.deptMutex.RUnlock()
+	return u.depts[id]
+}
+
+// 按名称查找部门，不区分大小写
+func (u *User) FindDepts(name string) []*Dept {
+	var l []*Dept
+	for _, dept := range u.GetDepts() {
+		if strings.EqualFold(dept.Name, name) {
+			l = append(l, dept)
+		}
+	}
+	return l
+}
+
+// 从顶级部门到当前部门的路径
+func (u *User) DeptPath(id int) []*Dept {
+	u.deptMutex.RLock()
+	defer u.deptMutex.RUnlock()
+
+	var path []*Dept
+	seen := make(map[int]bool)
+	for dept := u.depts[id]; dept != nil && !seen[dept.Id]; dept = u.depts[dept.Parent] {
+		seen[dept.Id] = true
+		path = append([]*Dept{dept}, path...)
+	}
+	return path
+}
+
+// 下级部门，recursive 为 true 时包含所有层级
+func (u *User) SubDepts(id int, recursive bool) []*Dept {
+	var l []*Dept
+	for _, dept := range u.GetDepts() {
+		if dept.Id == id {
+			continue
+		}
+		if dept.Parent == id || (recursive && u.isSubDept(dept.Id, id)) {
+			l = append(l, dept)
+		}
+	}
+	return l
+}
+
+func (u *User) isSubDept(id int, parent int) bool {
+	for _, dept := range u.DeptPath(id) {
+		if dept.Id == parent {
+			return true
+		}
+	}
+	return false
+}
+
+// 部门中的用户，recursive 为 true 时包含下级部门的用户
+func (u *User) UsersInDept(id int, recursive bool) []*UserProfile {
+	var l []*UserProfile
+	for _, user := range u.getUsersList() {
+		if user.Dept == id || (recursive && u.isSubDept(user.Dept, id)) {
+			l = append(l, user)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Id < l[j].Id
+	})
+	return l
+}
+
+// 按部门名称查找用户，同名部门的用户都会返回
+func (u *User) FindUsersByDept(name string, recursive bool) []*UserProfile {
+	var l []*UserProfile
+	seen := make(map[int]bool)
+	for _, dept := range u.FindDepts(name) {
+		for _, user := range u.UsersInDept(dept.Id, recursive) {
+			if !seen[user.Id] {
+				seen[user.Id] = true
+				l = append(l, user)
+			}
+		}
+	}
+	return l
+}
@@ -0,0 +1,283 @@
+package xxc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// 把汉字转换为拼音，每个字一个音节，如 "张三" -> ["zhang", "san"]
+// 本库不提供实现，需要拼音搜索时由调用方通过 UserOptions.Pinyin 传入
+type PinyinFunc func(s string) []string
+
+// 用户索引，账号区分大小写，其他 key 都经过 normalize
+type userIndex struct {
+	account  map[string]*UserProfile
+	email    map[string]*UserProfile
+	mobile   map[string]*UserProfile
+	realname map[string][]*UserProfile
+}
+
+func newUserIndex() *userIndex {
+	return &userIndex{
+		account:  make(map[string]*UserProfile),
+		email:    make(map[string]*UserProfile),
+		mobile:   make(map[string]*UserProfile),
+		realname: make(map[string][]*UserProfile),
+	}
+}
+
+func (idx *userIndex) add(user *UserProfile) {
+	if user.Account != "" {
+		idx.account[user.Account] = user
+	}
+	if k := normalize(user.Email); k != "" {
+		idx.email[k] = user
+	}
+	if k := normalize(user.Mobile); k != "" {
+		idx.mobile[k] = user
+	}
+	if k := normalize(user.Realname); k != "" {
+		idx.realname[k] = append(idx.realname[k], user)
+	}
+}
+
+func (idx *userIndex) remove(user *UserProfile) {
+	if idx.account[user.Account] == user {
+		delete(idx.account, user.Account)
+	}
+	if k := normalize(user.Email); idx.email[k] == user {
+		delete(idx.email, k)
+	}
+	if k := normalize(user.Mobile); idx.mobile[k] == user {
+		delete(idx.mobile, k)
+	}
+	k := normalize(user.Realname)
+	l := idx.realname[k]
+	for i, v := range l {
+		if v == user {
+			l = append(l[:i:i], l[i+1:]...)
+			break
+		}
+	}
+	if len(l) == 0 {
+		delete(idx.realname, k)
+	} else {
+		idx.realname[k] = l
+	}
+}
+
+// 转为小写，全角转半角，去掉空白
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == 0x3000:
+			continue
+		case r >= 0xff01 && r <= 0xff5e:
+			r -= 0xfee0
+		}
+		if unicode.IsSpace(r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// 搜索结果
+type UserCandidate struct {
+	User  *UserProfile
+	Score int // 匹配程度，越大越好
+}
+
+const (
+	scoreExact       = 100
+	scoreRealname    = 90
+	scoreContact     = 80 // 邮箱、手机
+	scorePinyin      = 70
+	scorePrefix      = 60
+	scoreContains    = 50
+	scoreTypo        = 40
+	scoreSubsequence = 30
+)
+
+func hasSubsequence(s string, sub string) bool {
+	rs := []rune(s)
+	i := 0
+	for _, r := range sub {
+		for i < len(rs) && rs[i] != r {
+			i++
+		}
+		if i == len(rs) {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// 编辑距离
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func matchScore(user *UserProfile, q string, pinyin PinyinFunc) int {
+	account := normalize(user.Account)
+	realname := normalize(user.Realname)
+
+	switch {
+	case account == q:
+		return scoreExact
+	case realname == q:
+		return scoreRealname
+	case q == normalize(user.Email) || q == normalize(user.Mobile):
+		return scoreContact
+	}
+
+	if pinyin != nil && realname != "" {
+		syllables := pinyin(user.Realname)
+		full := normalize(strings.Join(syllables, ""))
+		var initials strings.Builder
+		for _, s := range syllables {
+			if s = normalize(s); s != "" {
+				initials.WriteString(s[:1])
+			}
+		}
+		if q == full || q == initials.String() {
+			return scorePinyin
+		}
+		if strings.HasPrefix(full, q) {
+			return scorePrefix
+		}
+	}
+
+	score := 0
+	for _, name := range []string{realname, account} {
+		if name == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name, q):
+			return scorePrefix
+		case strings.Contains(name, q):
+			score = maxInt(score, scoreContains)
+		case len([]rune(q)) > 1 && levenshtein(name, q) == 1:
+			score = maxInt(score, scoreTypo)
+		case hasSubsequence(name, q):
+			score = maxInt(score, scoreSubsequence)
+		}
+	}
+	return score
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 通过邮箱查找用户
+func (u *User) GetUserByEmail(email string) *UserProfile {
+	u.usersMutex.RLock()
+	defer u.usersMutex.RUnlock()
+	return u.userIndex.email[normalize(email)]
+}
+
+// 通过手机号查找用户
+func (u *User) GetUserByMobile(mobile string) *UserProfile {
+	u.usersMutex.RLock()
+	defer u.usersMutex.RUnlock()
+	return u.userIndex.mobile[normalize(mobile)]
+}
+
+// 通过真实姓名查找用户，可能重名
+func (u *User) GetUsersByRealname(realname string) []*UserProfile {
+	u.usersMutex.RLock()
+	defer u.usersMutex.RUnlock()
+	return append([]*UserProfile(nil), u.userIndex.realname[normalize(realname)]...)
+}
+
+// 模糊搜索用户，按匹配程度排序，limit <= 0 时返回所有结果
+// 账号和姓名不区分大小写和全半角；设置了 Pinyin 时也可以用全拼或首字母匹配姓名，否则不支持拼音
+func (u *User) SearchUsers(query string, limit int) []*UserCandidate {
+	q := normalize(query)
+	if q == "" {
+		return nil
+	}
+
+	var l []*UserCandidate
+	for _, user := range u.getUsersList() {
+		if score := matchScore(user, q, u.Pinyin); score > 0 {
+			l = append(l, &UserCandidate{User: user, Score: score})
+		}
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Score != l[j].Score {
+			return l[i].Score > l[j].Score
+		}
+		return l[i].User.Id < l[j].User.Id
+	})
+	if limit > 0 && len(l) > limit {
+		l = l[:limit]
+	}
+	return l
+}
+
+// 把账号、姓名、邮箱或手机号解析为唯一的用户
+// 没有找到或者有多个同样匹配的用户时返回错误
+func (u *User) ResolveUser(name string) (*UserProfile, error) {
+	if user := u.GetUserByAccount(name); user != nil {
+		return user, nil
+	}
+
+	l := u.SearchUsers(name, 0)
+	if len(l) == 0 {
+		return nil, fmt.Errorf("%s is not a valid user", name)
+	}
+	// 匹配程度太低时不自动选择，避免发错人
+	if l[0].Score < scorePrefix {
+		return nil, fmt.Errorf("%s is not a valid user, maybe %s<%s>", name, l[0].User.Realname, l[0].User.Account)
+	}
+	if len(l) > 1 && l[1].Score == l[0].Score {
+		var names []string
+		for _, c := range l {
+			if c.Score != l[0].Score {
+				break
+			}
+			names = append(names, fmt.Sprintf("%s<%s>", c.User.Realname, c.User.Account))
+		}
+		return nil, fmt.Errorf("%s is ambiguous: %s", name, strings.Join(names, ", "))
+	}
+	return l[0].User, nil
+}
@@ -0,0 +1,78 @@
+// 机器人回答问题的后端
+package answer
+
+import (
+	"context"
+	"time"
+
+	"github.com/xjdrew/xxc/bot"
+	"github.com/xjdrew/xxc/ext/tuling123"
+)
+
+type Question struct {
+	Text     string
+	UserID   string // 对话 id，对话重置后变化，见 bot.Conversation.ID
+	UserName string // 提问者的姓名
+	GroupID  string // 群聊 id，私聊时为空
+
+	// 同一对话中之前的问答，后端没有对应的字段时忽略，见 Tuling
+	History []bot.Exchange
+}
+
+// 回答问题，没有答案时返回空字符串
+type Answerer interface {
+	Answer(q *Question) (string, error)
+}
+
+// 图灵机器人
+// 图灵接口没有传历史问答的字段，服务端按 UserID 记录上下文，所以忽略 Question.History；
+// 对话重置后 UserID 变化，服务端的上下文也随之重置
+type Tuling struct {
+	Client   *tuling123.Client
+	Location *tuling123.Location // 用户所在的位置，可以为空
+	Timeout  time.Duration       // 每个问题的超时，包括重试，默认 10 秒
+}
+
+func (t *Tuling) timeout() time.Duration {
+	if t.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return t.Timeout
+}
+
+func (t *Tuling) Answer(q *Question) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout())
+	defer cancel()
+
+	resp, err := t.Client.QueryContext(ctx, &tuling123.Query{
+		Text:     q.Text,
+		Location: t.Location,
+		UserID:   q.UserID,
+		GroupID:  q.GroupID,
+		UserName: q.UserName,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.String(), nil
+}
+
+// 后端出错时回复给用户的话，不需要告诉用户的错误返回空字符串
+func ErrorReply(err error) string {
+	if e, ok := err.(*tuling123.Error); ok {
+		switch {
+		case e.QuotaExceeded():
+			return "今天回答的问题太多了，明天再来吧"
+		case e.Unauthorized():
+			return "机器人配置有误，请联系管理员"
+		}
+		return ""
+	}
+	if err == context.DeadlineExceeded {
+		return "想得太久了，请稍后再问"
+	}
+	if e, ok := err.(interface{ Timeout() bool }); ok && e.Timeout() {
+		return "想得太久了，请稍后再问"
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package answer
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/xjdrew/xxc/ext/tuling123"
+)
+
+// 选择和配置后端，各个程序用 AddFlags 注册相同的命令行参数
+type Config struct {
+	Answerer string // tuling, http 或 rules
+
+	// http 后端，见 NewHTTP
+	URL      string
+	Request  string
+	Response string
+
+	// rules 后端，规则文件见 LoadRules
+	Rules string
+
+	// tuling 后端，APIKey 为空时不回答
+	Tuling tuling123.Client
+	City   string // 用户所在的城市
+
+	Timeout time.Duration // tuling 和 http 后端每个问题的超时，包括重试
+}
+
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Answerer, "answerer", "tuling", "backend answering questions: tuling, http or rules")
+	fs.StringVar(&c.URL, "answerURL", "", "http answerer: url of the json api")
+	fs.StringVar(&c.Request, "answerRequest", "", "http answerer: request body template, empty means "+DefaultRequestTemplate)
+	fs.StringVar(&c.Response, "answerResponse", "", "http answerer: template rendering the decoded json response, empty means "+DefaultResponseTemplate)
+	fs.StringVar(&c.Rules, "rules", "", "rules answerer: json file of regexp rules")
+
+	fs.StringVar(&c.Tuling.APIPath, "apiPath", "http://www.tuling123.com/openapi/api", "tuling123 api interface")
+	fs.StringVar(&c.Tuling.APIKey, "apiKey", "", "tuling123 api key, empty means not answering")
+	fs.IntVar(&c.Tuling.Version, "apiVersion", 1, "tuling123 api version, 1 or 2")
+	fs.IntVar(&c.Tuling.Retries, "apiRetries", 2, "tuling123 retries on network errors and 5xx")
+	fs.DurationVar(&c.Timeout, "apiTimeout", 10*time.Second, "tuling and http answerer: timeout per question, including retries")
+	fs.StringVar(&c.City, "city", "", "city of the users, helps answering local questions")
+}
+
+// 按配置创建后端，tuling 没有设置 APIKey 时返回 nil
+func (c *Config) New() (Answerer, error) {
+	switch c.Answerer {
+	case "tuling":
+		if c.Tuling.APIKey == "" {
+			return nil, nil
+		}
+		client := c.Tuling
+		a := &Tuling{Client: &client, Timeout: c.Timeout}
+		if c.City != "" {
+			a.Location = &tuling123.Location{City: c.City}
+		}
+		return a, nil
+	case "http":
+		a, err := NewHTTP(c.URL, c.Request, c.Response)
+		if err != nil {
+			return nil, err
+		}
+		a.Timeout = c.Timeout
+		return a, nil
+	case "rules":
+		return LoadRules(c.Rules)
+	default:
+		return nil, fmt.Errorf("unknown answerer: %s", c.Answerer)
+	}
+}
@@ -0,0 +1,133 @@
+package answer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	DefaultRequestTemplate  = `{"question": {{json .Text}}, "user": {{json .UserID}}, "group": {{json .GroupID}}, "history": {{json .History}}}`
+	DefaultResponseTemplate = `{{with .answer}}{{.}}{{end}}`
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// 通用的 http json 接口
+// 请求体由 Request 模板以 Question 渲染，返回的 json 解析后由 Response 模板渲染成答案
+type HTTP struct {
+	URL      string
+	Method   string            // 默认 POST
+	Header   map[string]string // 额外的请求头，如认证信息
+	Request  *template.Template
+	Response *template.Template
+	Client   *http.Client  // 为空时使用 http.DefaultClient
+	Timeout  time.Duration // 每个问题的超时，包括读取返回，默认 10 秒
+}
+
+func (h *HTTP) timeout() time.Duration {
+	if h.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return h.Timeout
+}
+
+func (h *HTTP) Answer(q *Question) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+
+	s, err := h.answer(ctx, q)
+	// 超时后读取返回的错误不一定带有 Timeout，统一返回 context 的错误，见 ErrorReply
+	if err != nil && ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return s, err
+}
+
+func (h *HTTP) answer(ctx context.Context, q *Question) (string, error) {
+	var body bytes.Buffer
+	if err := h.Request.Execute(&body, q); err != nil {
+		return "", err
+	}
+
+	method := h.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, h.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Header {
+		req.Header.Set(k, v)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+
+	var v interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return "", err
+	}
+
+	var answer bytes.Buffer
+	if err := h.Response.Execute(&answer, v); err != nil {
+		return "", err
+	}
+	// 返回中没有模板引用的字段
+	s := strings.TrimSpace(answer.String())
+	if s == "<no value>" {
+		return "", nil
+	}
+	return s, nil
+}
+
+// 模板为空时使用默认模板，模板中可以用 json 函数输出 json 值
+func NewHTTP(url string, request string, response string) (*HTTP, error) {
+	if url == "" {
+		return nil, errors.New("empty url")
+	}
+	if request == "" {
+		request = DefaultRequestTemplate
+	}
+	if response == "" {
+		response = DefaultResponseTemplate
+	}
+
+	reqTmpl, err := template.New("request").Funcs(templateFuncs).Parse(request)
+	if err != nil {
+		return nil, err
+	}
+	respTmpl, err := template.New("response").Funcs(templateFuncs).Parse(response)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTP{
+		URL:      url,
+		Request:  reqTmpl,
+		Response: respTmpl,
+	}, nil
+}
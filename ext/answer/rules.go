@@ -0,0 +1,81 @@
+package answer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"regexp"
+)
+
+// 一条规则，Pattern 为空时匹配所有问题，可以放在最后作为默认回答
+// 回答中可以用 ${1}、${name} 引用匹配的分组
+type Rule struct {
+	Pattern string   `json:"pattern"`
+	Reply   string   `json:"reply"`
+	Replies []string `json:"replies"` // 多个回答时随机选一个
+
+	re *regexp.Regexp
+}
+
+func (r *Rule) reply() string {
+	if len(r.Replies) > 0 {
+		return r.Replies[rand.Intn(len(r.Replies))]
+	}
+	return r.Reply
+}
+
+// 本地规则，按顺序匹配问题，不需要网络
+type Rules struct {
+	rules []*Rule
+}
+
+func (r *Rules) Answer(q *Question) (string, error) {
+	for _, rule := range r.rules {
+		if rule.re == nil {
+			return rule.reply(), nil
+		}
+		match := rule.re.FindStringSubmatchIndex(q.Text)
+		if match == nil {
+			continue
+		}
+		return string(rule.re.ExpandString(nil, rule.reply(), q.Text, match)), nil
+	}
+	return "", nil
+}
+
+func NewRules(rules []*Rule) (*Rules, error) {
+	for i, rule := range rules {
+		if rule.Reply == "" && len(rule.Replies) == 0 {
+			return nil, fmt.Errorf("rule %d: no reply", i)
+		}
+		if rule.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i, err)
+		}
+		rule.re = re
+	}
+	return &Rules{rules: rules}, nil
+}
+
+// 从 json 文件读取规则，如：
+//
+//	[
+//		{"pattern": "(?i)vpn", "reply": "VPN 的使用说明见 https://wiki.example.com/vpn"},
+//		{"pattern": "^请假(.*)", "reply": "请假${1}请在 OA 中提交"},
+//		{"replies": ["这个问题我还不会", "换个问题试试"]}
+//	]
+func LoadRules(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return NewRules(rules)
+}
@@ -0,0 +1,79 @@
+package answer
+
+import (
+	"crypto/md5"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/xjdrew/xxc/bot"
+)
+
+// 用 Answerer 回答不是命令的消息，记住每段对话的上下文，并限制提问的频率
+type Service struct {
+	Answerer Answerer   // 为空时不回答问题
+	Memory   bot.Memory // 每个用户或每个群的对话上下文
+	Guard    bot.Guard  // 提问限制
+}
+
+// 注册 Memory 和 Guard 的命令行参数，Answerer 的参数见 Config.AddFlags
+func (svc *Service) AddFlags(fs *flag.FlagSet) {
+	svc.Memory.AddFlags(fs)
+	svc.Guard.AddFlags(fs)
+}
+
+// 把不是命令的消息交给 Answer，并注册 reset 命令
+func (svc *Service) Register(router *bot.Router) {
+	router.Fallback = svc.Answer
+	router.HandleFunc("reset", "忘记之前的对话", svc.Reset)
+}
+
+// 回答不是命令的消息
+func (svc *Service) Answer(c *bot.Context) error {
+	if svc.Answerer == nil {
+		return nil
+	}
+
+	question := c.Text
+	log.Printf("\tquestion: %s", question)
+
+	if !svc.Guard.Admit(c) {
+		return nil
+	}
+
+	key := svc.Memory.Key(c.Message)
+	conversation := svc.Memory.Get(key)
+	q := &Question{
+		Text:    question,
+		UserID:  conversation.ID(),
+		History: conversation.Exchanges,
+	}
+	if c.Sender != nil {
+		q.UserName = c.Sender.Realname
+	}
+	if group := c.User.GetGroup(c.Message.Cgid); group != nil && group.Type != "one2one" {
+		q.GroupID = fmt.Sprintf("%x", md5.Sum([]byte(group.Gid)))
+	}
+
+	reply, err := svc.Answerer.Answer(q)
+	if err != nil {
+		log.Printf("\tanswer failed: %s", err)
+		svc.Guard.Release(c)
+		if reply := ErrorReply(err); reply != "" {
+			return c.Reply(reply)
+		}
+		return nil
+	}
+	if reply == "" {
+		return nil
+	}
+	log.Printf("\tanswer: %s", reply)
+	svc.Memory.Record(key, question, reply)
+	return c.Reply(reply)
+}
+
+// 忘记之前的对话
+func (svc *Service) Reset(c *bot.Context) error {
+	svc.Memory.Reset(svc.Memory.Key(c.Message))
+	return c.Reply("好的，我们重新开始吧")
+}
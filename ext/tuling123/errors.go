@@ -0,0 +1,101 @@
+package tuling123
+
+import (
+	"fmt"
+)
+
+// v1 接口的错误码
+const (
+	CodeKeyError      = 40001 // 参数 key 错误
+	CodeEmptyInfo     = 40002 // 请求内容 info 为空
+	CodeQuotaExceeded = 40004 // 当天请求次数已使用完
+	CodeBadFormat     = 40007 // 数据格式异常
+)
+
+// v2 接口的错误码
+const (
+	CodeV2NoResult      = 5000 // 无解析结果
+	CodeV2Unsupported   = 6000 // 暂不支持该功能
+	CodeV2BadRequest    = 4000 // 请求参数格式错误
+	CodeV2NoPermission  = 4002 // 无功能权限
+	CodeV2QuotaExceeded = 4003 // 该 apikey 没有可用请求次数
+	CodeV2InvalidKey    = 4007 // apikey 不合法
+	CodeV2EmptyInput    = 4600 // 输入内容为空
+	CodeV2InputTooLong  = 4602 // 输入文本内容超长
+	CodeV2ServerError   = 8008 // 服务器错误
+)
+
+var errorTexts = map[int]string{
+	CodeKeyError:        "invalid api key",
+	CodeEmptyInfo:       "empty question",
+	CodeQuotaExceeded:   "daily quota exceeded",
+	CodeBadFormat:       "bad request format",
+	CodeV2NoResult:      "no result",
+	CodeV2Unsupported:   "unsupported",
+	CodeV2BadRequest:    "bad request format",
+	4001:                "bad encryption",
+	CodeV2NoPermission:  "no permission",
+	CodeV2QuotaExceeded: "quota exceeded",
+	4005:                "no permission",
+	CodeV2InvalidKey:    "invalid api key",
+	4100:                "failed to get userid",
+	4200:                "bad upload format",
+	4300:                "too many batch operations",
+	4400:                "no valid userid uploaded",
+	4500:                "too many userids",
+	CodeV2EmptyInput:    "empty question",
+	CodeV2InputTooLong:  "question too long",
+	7002:                "upload failed",
+	CodeV2ServerError:   "server error",
+}
+
+// 图灵接口返回的错误码
+type Error struct {
+	Code int
+	Text string // 服务器返回的说明
+}
+
+func (e *Error) Error() string {
+	s := errorTexts[e.Code]
+	if s == "" {
+		s = "unknown error"
+	}
+	if e.Text != "" {
+		s += ": " + e.Text
+	}
+	return fmt.Sprintf("tuling123 %d %s", e.Code, s)
+}
+
+// 请求次数用完
+func (e *Error) QuotaExceeded() bool {
+	return e.Code == CodeQuotaExceeded || e.Code == CodeV2QuotaExceeded
+}
+
+// api key 错误或者没有权限
+func (e *Error) Unauthorized() bool {
+	switch e.Code {
+	case CodeKeyError, CodeV2InvalidKey, CodeV2NoPermission, 4005:
+		return true
+	}
+	return false
+}
+
+func isErrorCode(code int) bool {
+	_, ok := errorTexts[code]
+	return ok
+}
+
+// 接口返回了 200 以外的状态码
+type HTTPError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPError) Error() string {
+	return "tuling123: " + e.Status
+}
+
+// 是否值得重试
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode >= 500
+}
@@ -2,11 +2,24 @@ package tuling123
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
+)
+
+// v1 接口的结果代码
+const (
+	CodeText   = 100000 // 文本
+	CodeLink   = 200000 // 链接
+	CodeNews   = 302000 // 新闻
+	CodeRecipe = 308000 // 菜谱
+	CodeSong   = 313000 // 儿歌
+	CodePoem   = 314000 // 诗词
 )
 
 type Request struct {
@@ -17,66 +30,230 @@ type Request struct {
 }
 
 type ResponseListItem struct {
-	Article   string
-	Source    string
+	Article   string // 新闻标题
+	Source    string // 新闻来源
+	Name      string // 菜名
+	Info      string // 菜谱信息
 	Icon      string
 	Detailurl string
 }
 
+// 儿歌和诗词
+type ResponseFunction struct {
+	Song   string
+	Singer string
+	Name   string
+	Author string
+}
+
 type Response struct {
-	Code int
-	Text string
-	Url  string
-	List []ResponseListItem
+	Code     int
+	Text     string
+	Url      string
+	List     []ResponseListItem
+	Function *ResponseFunction
+	Image    string // 以下仅 v2 接口
+
+	Intent  *Intent
+	Results []Result
 }
 
 func (r *Response) String() string {
+	lines := []string{r.Text}
 	switch r.Code {
-	case 200000:
-		return fmt.Sprintf("%s\n%s", r.Text, r.Url)
-	case 302000:
-		var items []string
+	case CodeLink:
+		lines = append(lines, r.Url)
+	case CodeNews:
 		for _, item := range r.List {
-			items = append(items, fmt.Sprintf("* [%s【%s】](%s)", item.Article, item.Source, item.Detailurl))
+			lines = append(lines, fmt.Sprintf("* [%s【%s】](%s)", item.Article, item.Source, item.Detailurl))
+		}
+	case CodeRecipe:
+		for _, item := range r.List {
+			lines = append(lines, fmt.Sprintf("* [%s](%s)：%s", item.Name, item.Detailurl, item.Info))
+		}
+	case CodeSong:
+		if f := r.Function; f != nil {
+			lines = append(lines, fmt.Sprintf("%s - %s", f.Song, f.Singer))
+		}
+	case CodePoem:
+		if f := r.Function; f != nil {
+			lines = append(lines, fmt.Sprintf("%s - %s", f.Name, f.Author))
 		}
-		return fmt.Sprintf("%s\n%s", r.Text, strings.Join(items, "\n"))
 	default:
-		return r.Text
+		if r.Url != "" {
+			lines = append(lines, r.Url)
+		}
+	}
+	if r.Image != "" {
+		lines = append(lines, fmt.Sprintf("![](%s)", r.Image))
 	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// 一次提问
+type Query struct {
+	Text     string
+	ImageURL string    // 图片输入，仅 v2 接口
+	Location *Location // 提问者所在的位置，可以为空
+
+	UserID   string // 字母和数字，最长 32 位，机器人按 UserID 记录上下文
+	GroupID  string // 群聊 id，仅 v2 接口
+	UserName string // 群聊中的用户昵称，仅 v2 接口
 }
 
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
 // http://www.tuling123.com/help/h_cent_webapi.jhtml?nav=doc
 type Client struct {
 	APIPath string
 	APIKey  string
+	Version int // 接口版本，1 或 2，默认 1；v2 的 APIPath 为 http://openapi.tuling123.com/openapi/api/v2
+
+	HTTPClient *http.Client  // 为空时使用 10 秒超时的默认客户端
+	Retries    int           // 网络错误或服务器返回 5xx 时的重试次数
+	Backoff    time.Duration // 第一次重试前等待的时间，之后每次加倍，默认 500ms
 }
 
-func (c *Client) Ask(question string, id string) (*Response, error) {
-	treq := &Request{
-		Key:    c.APIKey,
-		Info:   question,
-		Userid: id,
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return defaultHTTPClient
+	}
+	return c.HTTPClient
+}
+
+func (c *Client) backoff() time.Duration {
+	if c.Backoff <= 0 {
+		return 500 * time.Millisecond
 	}
-	data, err := json.Marshal(treq)
+	return c.Backoff
+}
+
+func (c *Client) postOnce(ctx context.Context, data []byte, out interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, c.APIPath, bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return err
 	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
 
-	reader := bytes.NewReader(data)
-	resp, err := http.Post(c.APIPath, "application/json", reader)
+	resp, err := c.httpClient().Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(resp.Status)
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
 	dec := json.NewDecoder(resp.Body)
+	return dec.Decode(out)
+}
+
+// 可以重试的错误：网络错误和服务器 5xx
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var herr *HTTPError
+	if errors.As(err, &herr) {
+		return herr.Temporary()
+	}
+	var uerr *url.Error
+	return errors.As(err, &uerr)
+}
+
+func (c *Client) post(ctx context.Context, v interface{}, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	delay := c.backoff()
+	for i := 0; ; i++ {
+		err = c.postOnce(ctx, data, out)
+		if err == nil || i >= c.Retries || !retryable(ctx, err) {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) Ask(question string, id string) (*Response, error) {
+	return c.Query(&Query{Text: question, UserID: id})
+}
+
+func (c *Client) AskContext(ctx context.Context, question string, id string) (*Response, error) {
+	return c.QueryContext(ctx, &Query{Text: question, UserID: id})
+}
+
+func (c *Client) Query(q *Query) (*Response, error) {
+	return c.QueryContext(context.Background(), q)
+}
+
+// 提问，按 Version 使用 v1 或 v2 接口
+// 接口返回错误码时返回 *Error
+func (c *Client) QueryContext(ctx context.Context, q *Query) (*Response, error) {
+	if c.Version == 2 {
+		return c.queryV2(ctx, q)
+	}
+
+	if q.ImageURL != "" {
+		return nil, errors.New("image input requires api v2")
+	}
+	treq := &Request{
+		Key:    c.APIKey,
+		Info:   q.Text,
+		Userid: q.UserID,
+	}
+	if q.Location != nil {
+		treq.Loc = q.Location.String()
+	}
+
 	tresp := &Response{}
-	if err := dec.Decode(tresp); err != nil {
+	if err := c.post(ctx, treq, tresp); err != nil {
 		return nil, err
 	}
+	if isErrorCode(tresp.Code) {
+		return nil, &Error{Code: tresp.Code, Text: tresp.Text}
+	}
 	return tresp, nil
 }
+
+func (c *Client) queryV2(ctx context.Context, q *Query) (*Response, error) {
+	treq := &RequestV2{
+		ReqType: ReqTypeText,
+		UserInfo: UserInfo{
+			APIKey:     c.APIKey,
+			UserID:     q.UserID,
+			GroupID:    q.GroupID,
+			UserIDName: q.UserName,
+		},
+	}
+	if q.Text != "" {
+		treq.Perception.InputText = &InputText{Text: q.Text}
+	}
+	if q.ImageURL != "" {
+		treq.ReqType = ReqTypeImage
+		treq.Perception.InputImage = &InputURL{URL: q.ImageURL}
+	}
+	if q.Location != nil {
+		treq.Perception.SelfInfo = &SelfInfo{Location: q.Location}
+	}
+
+	tresp := &ResponseV2{}
+	if err := c.post(ctx, treq, tresp); err != nil {
+		return nil, err
+	}
+	resp := tresp.Response()
+	if isErrorCode(resp.Code) {
+		return nil, &Error{Code: resp.Code, Text: resp.Text}
+	}
+	return resp, nil
+}
@@ -0,0 +1,132 @@
+package tuling123
+
+import (
+	"strings"
+)
+
+// 请求类型
+const (
+	ReqTypeText  = 0
+	ReqTypeImage = 1
+	ReqTypeMedia = 2
+)
+
+type Location struct {
+	City     string `json:"city,omitempty"`
+	Province string `json:"province,omitempty"`
+	Street   string `json:"street,omitempty"`
+}
+
+func (l *Location) String() string {
+	return l.Province + l.City + l.Street
+}
+
+type InputText struct {
+	Text string `json:"text"`
+}
+
+type InputURL struct {
+	URL string `json:"url"`
+}
+
+type SelfInfo struct {
+	Location *Location `json:"location,omitempty"`
+}
+
+type Perception struct {
+	InputText  *InputText `json:"inputText,omitempty"`
+	InputImage *InputURL  `json:"inputImage,omitempty"`
+	InputMedia *InputURL  `json:"inputMedia,omitempty"`
+	SelfInfo   *SelfInfo  `json:"selfInfo,omitempty"`
+}
+
+type UserInfo struct {
+	APIKey     string `json:"apiKey"`
+	UserID     string `json:"userId"`               // 字母和数字，最长 32 位
+	GroupID    string `json:"groupId,omitempty"`    // 最长 64 位
+	UserIDName string `json:"userIdName,omitempty"` // 群聊中的用户昵称
+}
+
+// http://doc.tuling123.com/openapi2/263611
+type RequestV2 struct {
+	ReqType    int        `json:"reqType"`
+	Perception Perception `json:"perception"`
+	UserInfo   UserInfo   `json:"userInfo"`
+}
+
+type Intent struct {
+	Code       int                    `json:"code"`
+	IntentName string                 `json:"intentName"`
+	ActionName string                 `json:"actionName"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+type NewsItem struct {
+	Name      string `json:"name"`
+	Info      string `json:"info"`
+	Icon      string `json:"icon"`
+	DetailURL string `json:"detailurl"`
+}
+
+type ResultValues struct {
+	Text  string     `json:"text"`
+	URL   string     `json:"url"`
+	Image string     `json:"image"`
+	Voice string     `json:"voice"`
+	Video string     `json:"video"`
+	News  []NewsItem `json:"news"`
+}
+
+type Result struct {
+	GroupType  int          `json:"groupType"`
+	ResultType string       `json:"resultType"` // text, url, image, voice, video, news
+	Values     ResultValues `json:"values"`
+}
+
+type ResponseV2 struct {
+	Intent  Intent   `json:"intent"`
+	Results []Result `json:"results"`
+}
+
+// 转换为 v1 接口的格式，Code 按结果类型转换为 v1 的结果代码，出错时为 v2 的错误码
+func (r *ResponseV2) Response() *Response {
+	resp := &Response{
+		Intent:  &r.Intent,
+		Results: r.Results,
+	}
+
+	var texts []string
+	for _, result := range r.Results {
+		v := result.Values
+		switch result.ResultType {
+		case "text":
+			texts = append(texts, v.Text)
+		case "url":
+			resp.Url = v.URL
+		case "image":
+			resp.Image = v.Image
+		case "news":
+			for _, item := range v.News {
+				resp.List = append(resp.List, ResponseListItem{
+					Article:   item.Name,
+					Source:    item.Info,
+					Icon:      item.Icon,
+					Detailurl: item.DetailURL,
+				})
+			}
+		}
+	}
+	resp.Text = strings.Join(texts, "\n")
+
+	switch {
+	case r.Intent.Code < 10000:
+		resp.Code = r.Intent.Code
+	case len(resp.List) > 0:
+		resp.Code = CodeNews
+	case resp.Url != "":
+		resp.Code = CodeLink
+	default:
+		resp.Code = CodeText
+	}
+	return resp
+}
@@ -0,0 +1,100 @@
+package xxc
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// one2one 会话的 gid 是 "1&2"，其他会话是 uuid
+var gidPattern = regexp.MustCompile(`^(\d+&\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})$`)
+
+// 会话查询条件，零值的条件不做限制
+type GroupFilter struct {
+	Name    string // 会话名称，不区分大小写
+	Partial bool   // 为 true 时名称按子串匹配
+	Type    string // 会话类型，如 one2one, group
+	Member  int    // 包含此用户
+	Public  *bool  // 是否公共会话
+	Star    *bool  // 是否收藏
+	Hide    *bool  // 是否隐藏
+}
+
+func matchFlag(want *bool, v int) bool {
+	return want == nil || *want == (v != 0)
+}
+
+func (f *GroupFilter) match(g *ChatGroup) bool {
+	if f.Name != "" {
+		name, want := normalize(g.Name), normalize(f.Name)
+		if f.Partial {
+			if !strings.Contains(name, want) {
+				return false
+			}
+		} else if name != want {
+			return false
+		}
+	}
+	if f.Type != "" && f.Type != g.Type {
+		return false
+	}
+	if f.Member != 0 && !g.IsInGroup(f.Member) {
+		return false
+	}
+	return matchFlag(f.Public, g.Public) && matchFlag(f.Star, g.Star) && matchFlag(f.Hide, g.Hide)
+}
+
+func sortGroups(l []*ChatGroup) {
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Name != l[j].Name {
+			return l[i].Name < l[j].Name
+		}
+		return l[i].Gid < l[j].Gid
+	})
+}
+
+// 查找符合条件的会话，按名称排序
+func (u *User) FindGroups(filter *GroupFilter) []*ChatGroup {
+	u.groupMutex.RLock()
+	defer u.groupMutex.RUnlock()
+
+	var l []*ChatGroup
+	for _, group := range u.groups {
+		if filter.match(group) {
+			l = append(l, group)
+		}
+	}
+	sortGroups(l)
+	return l
+}
+
+// 用户所在的所有会话
+func (u *User) GroupsOf(id int) []*ChatGroup {
+	return u.FindGroups(&GroupFilter{Member: id})
+}
+
+// 通过 gid 或会话名称查找唯一的会话
+// 会话列表中没有、但看起来是 gid 时，返回只有 Gid 的会话，由服务器判断会话是否存在
+func (u *User) ResolveGroup(name string) (*ChatGroup, error) {
+	if group := u.GetGroup(name); group != nil {
+		return group, nil
+	}
+
+	l := u.FindGroups(&GroupFilter{Name: name})
+	switch len(l) {
+	case 0:
+		if gidPattern.MatchString(name) {
+			return &ChatGroup{Gid: name}, nil
+		}
+		return nil, fmt.Errorf("%s is not a valid group", name)
+	case 1:
+		return l[0], nil
+	default:
+		var gids []string
+		for _, group := range l {
+			gids = append(gids, group.Gid)
+		}
+		return nil, fmt.Errorf("%s is ambiguous: %s", name, strings.Join(gids, ", "))
+	}
+}
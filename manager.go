@@ -0,0 +1,301 @@
+package xxc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownAccount = errors.New("unknown account")
+	ErrOffline        = errors.New("account is offline")
+)
+
+// Manager 中一个账号的配置
+type AccountConfig struct {
+	ClientConfig
+	Credentials string // 凭证文件，见 LoadCredentialsFile
+	Outbox      string // 发件箱目录，为空时不使用发件箱
+}
+
+// 从 json 文件读取账号列表，如：
+//
+//	[{"host": "https://im.example.com:11443", "user": "alert", "credentials": "alert.cred"}]
+func LoadAccountConfigs(path string) ([]*AccountConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*AccountConfig
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return accounts, nil
+}
+
+// 一个账号的登录会话
+type session struct {
+	config  *ClientConfig
+	outbox  *Outbox
+	tracker *MessageTracker // 多次登录之间共享，用于补齐断线期间的消息
+
+	mu   sync.RWMutex
+	user *User
+}
+
+func (s *session) getUser() *User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.user
+}
+
+func (s *session) setUser(user *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// 管理多个账号的登录会话，断线后自动重新登录，按账号发送消息
+type Manager struct {
+	RetryDelay time.Duration // 断线或登录失败后等待多久再登录，默认 5 秒
+
+	// 账号上线和下线时调用，每次重新登录都会创建新的 User
+	// 消息处理函数应该在 Online 中注册
+	Online  func(user *User)
+	Offline func(user *User)
+
+	accounts []string
+	sessions map[string]*session
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (m *Manager) retryDelay() time.Duration {
+	if m.RetryDelay <= 0 {
+		return 5 * time.Second
+	}
+	return m.RetryDelay
+}
+
+// 等待 d，Manager 停止时返回 false
+func (m *Manager) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-m.stop:
+		return false
+	}
+}
+
+// 喧喧服务器有bug：
+//
+//	如果登录时踢人下线，其他客户端可能先收到用户登录，后马上又收到用户下线通知，导致状态不对
+//	快速的登录并退出一次，确保状态干净
+func (s *session) flash() {
+	client := NewClient(s.config)
+	user, err := CreateUser(client)
+	if err != nil {
+		return
+	}
+	user.Fini()
+}
+
+func (m *Manager) serve(s *session) {
+	account := s.config.User
+	for {
+		client := NewClient(s.config)
+		user, err := CreateUserWithOptions(client, &UserOptions{
+			Tracker: s.tracker,
+			Outbox:  s.outbox,
+		})
+		if err != nil {
+			log.Printf("%s login failed: %s", account, err)
+			if !m.sleep(m.retryDelay()) {
+				return
+			}
+			continue
+		}
+
+		done := make(chan error, 1)
+		client.Mux.HandleFunc("chat.kickoff", func(resp *Response) {
+			select {
+			case done <- errors.New(resp.Message):
+			default:
+			}
+		})
+		client.HandleConnectionError(func(err error) {
+			select {
+			case done <- err:
+			default:
+			}
+		})
+
+		log.Printf("%s online", account)
+		s.setUser(user)
+		if m.Online != nil {
+			m.Online(user)
+		}
+
+		if err := user.CatchUp(); err != nil {
+			log.Printf("%s catch up failed: %s", account, err)
+		}
+
+		stopped := false
+		select {
+		case err := <-done:
+			log.Printf("%s offline: %s", account, err)
+		case <-m.stop:
+			log.Printf("%s offline: manager stopped", account)
+			stopped = true
+		}
+
+		s.setUser(nil)
+		if m.Offline != nil {
+			m.Offline(user)
+		}
+		user.Fini()
+
+		if stopped || !m.sleep(m.retryDelay()) {
+			return
+		}
+		s.flash()
+		if !m.sleep(1 * time.Second) {
+			return
+		}
+
+		if s.config.Metrics != nil {
+			s.config.Metrics.Reconnect()
+		}
+	}
+}
+
+// 登录所有账号并保持在线，直到调用 Stop
+func (m *Manager) Serve() error {
+	var wg sync.WaitGroup
+	for _, account := range m.accounts {
+		wg.Add(1)
+		go func(s *session) {
+			defer wg.Done()
+			m.serve(s)
+		}(m.sessions[account])
+	}
+	wg.Wait()
+	return nil
+}
+
+// 所有账号退出登录，Serve 随后返回
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// 所有账号，按配置顺序
+func (m *Manager) Accounts() []string {
+	return append([]string(nil), m.accounts...)
+}
+
+func (m *Manager) session(account string) (*session, error) {
+	if account == "" && len(m.accounts) > 0 {
+		account = m.accounts[0]
+	}
+	s := m.sessions[account]
+	if s == nil {
+		return nil, ErrUnknownAccount
+	}
+	return s, nil
+}
+
+// 账号当前登录的 User，account 为空时表示第一个账号
+func (m *Manager) User(account string) (*User, error) {
+	s, err := m.session(account)
+	if err != nil {
+		return nil, err
+	}
+	user := s.getUser()
+	if user == nil {
+		return nil, ErrOffline
+	}
+	return user, nil
+}
+
+// 账号的发件箱，没有配置时返回 nil
+func (m *Manager) Outbox(account string) *Outbox {
+	s, err := m.session(account)
+	if err != nil {
+		return nil
+	}
+	return s.outbox
+}
+
+// 以 account 的身份发送消息，见 User.QueueMessage
+func (m *Manager) SendMessage(account string, gid string, msg Message) (*ChatMessage, error) {
+	user, err := m.User(account)
+	if err != nil {
+		return nil, err
+	}
+	return user.QueueMessage(gid, msg)
+}
+
+func (m *Manager) SayToGroup(account string, gid string, content string) error {
+	user, err := m.User(account)
+	if err != nil {
+		return err
+	}
+	return user.SayToGroup(gid, content)
+}
+
+func (m *Manager) SayToUser(account string, to string, content string) error {
+	user, err := m.User(account)
+	if err != nil {
+		return err
+	}
+	return user.SayToUser(to, content)
+}
+
+// 创建 Manager，读取各账号的凭证并打开发件箱，读取后删除凭证的环境变量
+func NewManager(accounts []*AccountConfig) (*Manager, error) {
+	if len(accounts) == 0 {
+		return nil, errors.New("no accounts")
+	}
+
+	m := &Manager{
+		sessions: make(map[string]*session),
+		stop:     make(chan struct{}),
+	}
+
+	for _, account := range accounts {
+		config := account.ClientConfig
+		if config.User == "" {
+			return nil, errors.New("account without user")
+		}
+		if m.sessions[config.User] != nil {
+			return nil, fmt.Errorf("duplicate account: %s", config.User)
+		}
+		if err := LoadCredentials(&config, account.Credentials); err != nil {
+			return nil, fmt.Errorf("%s: %s", config.User, err)
+		}
+
+		s := &session{
+			config:  &config,
+			tracker: NewMessageTracker(),
+		}
+		if account.Outbox != "" {
+			outbox, err := OpenOutbox(account.Outbox)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", config.User, err)
+			}
+			s.outbox = outbox
+		}
+
+		m.accounts = append(m.accounts, config.User)
+		m.sessions[config.User] = s
+	}
+	UnsetCredentialsEnv()
+	return m, nil
+}
@@ -0,0 +1,119 @@
+package xxc
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 消息中的一次 @
+type mention struct {
+	user       int
+	start, end int // 在消息内容中的位置，包含 @ 符号
+}
+
+func isWordRune(r rune) bool {
+	return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+// 名字后紧跟字母数字时不算匹配，避免 @bob 匹配到 @bobby
+func matchName(s string, name string) bool {
+	if name == "" || !strings.HasPrefix(s, name) {
+		return false
+	}
+	last, _ := utf8.DecodeLastRuneInString(name)
+	next, _ := utf8.DecodeRuneInString(s[len(name):])
+	return !(isWordRune(last) && isWordRune(next))
+}
+
+// 查找内容中所有 @account 或 @realname，同一位置取最长匹配
+// @ 前面是字母数字时不算，避免把 a@bob.com 这样的邮箱当作 @bob
+func (u *User) findMentions(content string) []mention {
+	u.usersMutex.RLock()
+	defer u.usersMutex.RUnlock()
+
+	var mentions []mention
+	for i := 0; i < len(content); {
+		index := strings.IndexByte(content[i:], '@')
+		if index == -1 {
+			break
+		}
+		start := i + index
+		if prev, _ := utf8.DecodeLastRuneInString(content[:start]); start > 0 && isWordRune(prev) {
+			i = start + 1
+			continue
+		}
+		rest := content[start+1:]
+
+		best := mention{start: start, end: -1}
+		for _, user := range u.users {
+			for _, name := range []string{user.Account, user.Realname} {
+				if matchName(rest, name) && start+1+len(name) > best.end {
+					best.user = user.Id
+					best.end = start + 1 + len(name)
+				}
+			}
+		}
+
+		if best.end == -1 {
+			i = start + 1
+			continue
+		}
+		mentions = append(mentions, best)
+		i = best.end
+	}
+	return mentions
+}
+
+// 解析消息中 @ 到的用户ID，按出现顺序去重
+func (u *User) ParseMentions(m *ChatMessage) []int {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, mt := range u.findMentions(m.Content) {
+		if !seen[mt.user] {
+			seen[mt.user] = true
+			ids = append(ids, mt.user)
+		}
+	}
+	return ids
+}
+
+// 消息是否 @ 了当前用户
+func (u *User) MentionsMe(m *ChatMessage) bool {
+	for _, mt := range u.findMentions(m.Content) {
+		if mt.user == u.profile.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// 去掉消息中对指定用户的 @，不指定用户时去掉所有 @
+func (u *User) StripMentions(m *ChatMessage, ids ...int) string {
+	var b strings.Builder
+	last := 0
+	for _, mt := range u.findMentions(m.Content) {
+		if len(ids) > 0 && !containsInt(ids, mt.user) {
+			continue
+		}
+		b.WriteString(m.Content[last:mt.start])
+		last = mt.end
+	}
+	b.WriteString(m.Content[last:])
+	return strings.TrimSpace(b.String())
+}
+
+// 发送 @ 指定用户的消息，被 @ 的用户客户端会收到提醒
+func (u *User) SayWithMentions(gid string, content string, ids ...int) error {
+	md := NewMarkdown()
+	for _, id := range ids {
+		user := u.GetUserById(id)
+		if user == nil {
+			return fmt.Errorf("%d is not a valid user id", id)
+		}
+		md.Mention(user)
+	}
+	md.Text(content)
+	return u.SendMessage(gid, md)
+}
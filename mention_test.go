@@ -0,0 +1,49 @@
+package xxc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMentions(t *testing.T) {
+	u := &User{
+		profile: &UserProfile{Id: 1, Account: "bot", Realname: "Bot"},
+		users: map[int]*UserProfile{
+			1: {Id: 1, Account: "bot", Realname: "Bot"},
+			2: {Id: 2, Account: "bob", Realname: "张三"},
+			3: {Id: 3, Account: "bobby", Realname: "张三丰"},
+		},
+	}
+
+	cases := []struct {
+		content  string
+		ids      []int
+		stripped string // 去掉所有 @ 后的文本
+	}{
+		{"@bot hello", []int{1}, "hello"},
+		{"mail a@bob.com", nil, "mail a@bob.com"},
+		{"ping x@bot.com", nil, "ping x@bot.com"},
+		{"@bob, hi", []int{2}, ", hi"},
+		{"@bob.com", []int{2}, ".com"},
+		{"@bobby", []int{3}, ""},
+		{"@bobx", nil, "@bobx"},
+		{"@张三丰 你好", []int{3}, "你好"},
+		{"@张三 你好", []int{2}, "你好"},
+		{"@张三你好", []int{2}, "你好"},
+		{"你好@张三", []int{2}, "你好"},
+		{"(@bob) @bot", []int{2, 1}, "()"},
+		{"@bob @bob", []int{2}, ""},
+	}
+	for _, c := range cases {
+		m := &ChatMessage{Content: c.content}
+		if ids := u.ParseMentions(m); !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("ParseMentions(%q) = %v, want %v", c.content, ids, c.ids)
+		}
+		if s := u.StripMentions(m); s != c.stripped {
+			t.Errorf("StripMentions(%q) = %q, want %q", c.content, s, c.stripped)
+		}
+		if me := u.MentionsMe(m); me != containsInt(c.ids, 1) {
+			t.Errorf("MentionsMe(%q) = %v", c.content, me)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package xxc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// 喧喧消息内容类型
+const (
+	ContentTypeText     = "text"     // 文本，客户端按 markdown 渲染
+	ContentTypePlain    = "plain"    // 纯文本，不做 markdown 渲染
+	ContentTypeEmoticon = "emoticon" // 表情
+	ContentTypeObject   = "object"   // 对象，如链接卡片
+)
+
+// 待发送的消息
+type Message interface {
+	ContentType() string
+	Content() string
+}
+
+type message struct {
+	contentType string
+	content     string
+}
+
+func (m *message) ContentType() string {
+	return m.contentType
+}
+
+func (m *message) Content() string {
+	return m.content
+}
+
+// 普通文本消息
+func Text(s string) Message {
+	return &message{ContentTypeText, s}
+}
+
+// 纯文本消息，markdown 标记原样显示
+func Plain(s string) Message {
+	return &message{ContentTypePlain, s}
+}
+
+// 代码块消息
+func Code(lang string, code string) Message {
+	return NewMarkdown().Code(lang, code)
+}
+
+// 表情消息，name 为 emojione 短名称，如 "smile"
+func Emoticon(name string) Message {
+	return &message{ContentTypeEmoticon, ":" + strings.Trim(name, ":") + ":"}
+}
+
+// 链接卡片
+type URLCard struct {
+	Url     string `json:"url"`
+	Title   string `json:"title,omitempty"`
+	Desc    string `json:"desc,omitempty"`
+	Image   string `json:"image,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+func (c *URLCard) ContentType() string {
+	return ContentTypeObject
+}
+
+func (c *URLCard) Content() string {
+	v, err := json.Marshal(struct {
+		Type string `json:"type"`
+		*URLCard
+	}{"url", c})
+	if err != nil {
+		log.Printf("marshal url card failed: %s", c.Url)
+		return ""
+	}
+	return string(v)
+}
+
+// markdown 消息构造器
+type Markdown struct {
+	buf bytes.Buffer
+}
+
+func NewMarkdown() *Markdown {
+	return &Markdown{}
+}
+
+func (m *Markdown) ContentType() string {
+	return ContentTypeText
+}
+
+func (m *Markdown) Content() string {
+	return m.buf.String()
+}
+
+func (m *Markdown) Text(s string) *Markdown {
+	m.buf.WriteString(s)
+	return m
+}
+
+func (m *Markdown) Textf(format string, a ...interface{}) *Markdown {
+	fmt.Fprintf(&m.buf, format, a...)
+	return m
+}
+
+// 换行，markdown 中段落之间需要空行
+func (m *Markdown) Line() *Markdown {
+	m.buf.WriteString("\n\n")
+	return m
+}
+
+func (m *Markdown) Bold(s string) *Markdown {
+	m.buf.WriteString("**" + s + "**")
+	return m
+}
+
+func (m *Markdown) Link(title string, url string) *Markdown {
+	fmt.Fprintf(&m.buf, "[%s](%s)", title, url)
+	return m
+}
+
+// @某个用户
+func (m *Markdown) Mention(user *UserProfile) *Markdown {
+	m.buf.WriteString("@" + user.Realname + " ")
+	return m
+}
+
+func (m *Markdown) Code(lang string, code string) *Markdown {
+	m.ensureNewline()
+	fmt.Fprintf(&m.buf, "```%s\n%s\n```\n", lang, strings.TrimRight(code, "\n"))
+	return m
+}
+
+func (m *Markdown) List(items ...string) *Markdown {
+	m.ensureNewline()
+	for _, item := range items {
+		m.buf.WriteString("* " + item + "\n")
+	}
+	return m
+}
+
+func (m *Markdown) Table(header []string, rows [][]string) *Markdown {
+	m.ensureNewline()
+	m.tableRow(header)
+	sep := make([]string, len(header))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	m.tableRow(sep)
+	for _, row := range rows {
+		m.tableRow(row)
+	}
+	return m
+}
+
+func (m *Markdown) tableRow(cells []string) {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = strings.Replace(strings.Replace(cell, "|", "\\|", -1), "\n", " ", -1)
+	}
+	m.buf.WriteString("| " + strings.Join(escaped, " | ") + " |\n")
+}
+
+func (m *Markdown) ensureNewline() {
+	if m.buf.Len() > 0 && !bytes.HasSuffix(m.buf.Bytes(), []byte("\n")) {
+		m.buf.WriteString("\n")
+	}
+}
@@ -0,0 +1,198 @@
+package xxc
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 客户端指标，可以接入其他监控系统
+type Metrics interface {
+	FrameIn(method string)                              // 收到一帧
+	FrameOut(method string)                             // 发出一帧
+	CallDone(method string, d time.Duration, err error) // 一次调用结束
+	HandlerDone(method string, d time.Duration)         // 一次通知处理结束
+	PendingCalls(delta int)                             // 等待返回的调用数变化
+	Reconnect()                                         // 重新连接
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) FrameIn(string)                        {}
+func (nopMetrics) FrameOut(string)                       {}
+func (nopMetrics) CallDone(string, time.Duration, error) {}
+func (nopMetrics) HandlerDone(string, time.Duration)     {}
+func (nopMetrics) PendingCalls(int)                      {}
+func (nopMetrics) Reconnect()                            {}
+
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // 与 buckets 对应，不累加
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range defaultBuckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Prometheus 文本格式的指标，同时是输出指标的 http.Handler
+type PrometheusMetrics struct {
+	mu sync.Mutex
+
+	framesIn   map[string]uint64
+	framesOut  map[string]uint64
+	callErrors map[string]uint64
+	calls      map[string]*histogram
+	handlers   map[string]*histogram
+	pending    int64
+	reconnects uint64
+}
+
+func (m *PrometheusMetrics) FrameIn(method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.framesIn[method]++
+}
+
+func (m *PrometheusMetrics) FrameOut(method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.framesOut[method]++
+}
+
+func observe(hs map[string]*histogram, method string, d time.Duration) {
+	h := hs[method]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(defaultBuckets))}
+		hs[method] = h
+	}
+	h.observe(d.Seconds())
+}
+
+func (m *PrometheusMetrics) CallDone(method string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observe(m.calls, method, d)
+	if err != nil {
+		m.callErrors[method]++
+	}
+}
+
+func (m *PrometheusMetrics) HandlerDone(method string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observe(m.handlers, method, d)
+}
+
+func (m *PrometheusMetrics) PendingCalls(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending += int64(delta)
+}
+
+func (m *PrometheusMetrics) Reconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reconnects++
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]uint64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(buf *bytes.Buffer, name string, typ string, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeCounterVec(buf *bytes.Buffer, name string, help string, m map[string]uint64) {
+	writeHeader(buf, name, "counter", help)
+	for _, method := range sortedKeys(m) {
+		fmt.Fprintf(buf, "%s{method=\"%s\"} %d\n", name, escapeLabel(method), m[method])
+	}
+}
+
+func writeHistogramVec(buf *bytes.Buffer, name string, help string, m map[string]*histogram) {
+	writeHeader(buf, name, "histogram", help)
+	for _, method := range sortedKeys(m) {
+		h := m[method]
+		label := escapeLabel(method)
+		var cumulative uint64
+		for i, b := range defaultBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(buf, "%s_bucket{method=\"%s\",le=\"%s\"} %d\n", name, label, formatFloat(b), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket{method=\"%s\",le=\"+Inf\"} %d\n", name, label, h.count)
+		fmt.Fprintf(buf, "%s_sum{method=\"%s\"} %s\n", name, label, formatFloat(h.sum))
+		fmt.Fprintf(buf, "%s_count{method=\"%s\"} %d\n", name, label, h.count)
+	}
+}
+
+// 按 Prometheus 文本格式输出所有指标
+func (m *PrometheusMetrics) writeText(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeCounterVec(buf, "xxc_frames_in_total", "Frames received from server.", m.framesIn)
+	writeCounterVec(buf, "xxc_frames_out_total", "Frames sent to server.", m.framesOut)
+	writeHistogramVec(buf, "xxc_call_duration_seconds", "Call latency.", m.calls)
+	writeCounterVec(buf, "xxc_call_errors_total", "Failed calls.", m.callErrors)
+	writeHistogramVec(buf, "xxc_handler_duration_seconds", "Notification handler duration.", m.handlers)
+
+	writeHeader(buf, "xxc_pending_calls", "gauge", "Calls waiting for response.")
+	fmt.Fprintf(buf, "xxc_pending_calls %d\n", m.pending)
+	writeHeader(buf, "xxc_reconnects_total", "counter", "Reconnects to server.")
+	fmt.Fprintf(buf, "xxc_reconnects_total %d\n", m.reconnects)
+}
+
+func (m *PrometheusMetrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	m.writeText(&buf)
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	rw.Write(buf.Bytes())
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		framesIn:   make(map[string]uint64),
+		framesOut:  make(map[string]uint64),
+		callErrors: make(map[string]uint64),
+		calls:      make(map[string]*histogram),
+		handlers:   make(map[string]*histogram),
+	}
+}
@@ -0,0 +1,240 @@
+package xxc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 发件箱中消息的状态
+const (
+	OutboxPending = "pending" // 等待发送
+	OutboxSent    = "sent"    // 已经写入连接，不代表服务器已经收到
+	OutboxFailed  = "failed"  // 多次重试后仍然失败，不再重试
+)
+
+const (
+	outboxMaxAttempts = 10
+	outboxKeep        = 24 * time.Hour // 发送完成的消息保留多久，用于查询状态
+	outboxMaxEntries  = 10000
+)
+
+type OutboxEntry struct {
+	Message  *ChatMessage `json:"message"`
+	Status   string       `json:"status"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error,omitempty"` // 最后一次发送失败的原因
+	Created  time.Time    `json:"created"`
+	Updated  time.Time    `json:"updated"`
+}
+
+// 持久化的发件箱，每条消息一个文件，以消息 gid 为 key
+// 发送失败的消息在重新登录后按创建顺序重发，服务器按 gid 去重
+// 同一个 Outbox 可以在多次登录创建的 User 之间共享
+type Outbox struct {
+	dir string
+
+	mu      sync.Mutex
+	entries map[string]*OutboxEntry
+}
+
+func (o *Outbox) path(gid string) string {
+	return filepath.Join(o.dir, url.PathEscape(gid)+".json")
+}
+
+// 先写临时文件再改名，避免写了一半的文件
+func (o *Outbox) save(e *OutboxEntry) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	path := o.path(e.Message.Gid)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, v, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// 清理发送完成并且过期的消息
+func (o *Outbox) prune(now time.Time) {
+	for gid, e := range o.entries {
+		if e.Status != OutboxPending && now.Sub(e.Updated) > outboxKeep {
+			delete(o.entries, gid)
+			os.Remove(o.path(gid))
+		}
+	}
+}
+
+func (o *Outbox) add(m *ChatMessage) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.entries[m.Gid]; ok {
+		return nil
+	}
+
+	now := time.Now()
+	if len(o.entries) >= outboxMaxEntries {
+		o.prune(now)
+	}
+
+	e := &OutboxEntry{
+		Message: m,
+		Status:  OutboxPending,
+		Created: now,
+		Updated: now,
+	}
+	o.entries[m.Gid] = e
+	return o.save(e)
+}
+
+// 记录一次发送结果，顺便清理过期的消息
+func (o *Outbox) done(gid string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e := o.entries[gid]
+	if e == nil {
+		return
+	}
+
+	e.Attempts++
+	e.Updated = time.Now()
+	if err == nil {
+		e.Status = OutboxSent
+		e.Error = ""
+	} else {
+		e.Error = err.Error()
+		if e.Attempts >= outboxMaxAttempts {
+			e.Status = OutboxFailed
+		}
+	}
+
+	if err := o.save(e); err != nil {
+		log.Printf("save outbox entry %s failed: %s", gid, err)
+	}
+	o.prune(e.Updated)
+}
+
+// 等待发送的消息，按创建时间排序
+func (o *Outbox) pending() []*ChatMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var l []*OutboxEntry
+	for _, e := range o.entries {
+		if e.Status == OutboxPending {
+			l = append(l, e)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Created.Before(l[j].Created)
+	})
+
+	messages := make([]*ChatMessage, len(l))
+	for i, e := range l {
+		messages[i] = e.Message
+	}
+	return messages
+}
+
+// 查询消息的发送状态，消息不存在时返回 nil
+func (o *Outbox) Status(gid string) *OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e := o.entries[gid]
+	if e == nil {
+		return nil
+	}
+	v := *e
+	return &v
+}
+
+func (o *Outbox) load() error {
+	files, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(o.dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		e := &OutboxEntry{}
+		if err := json.Unmarshal(data, e); err != nil || e.Message == nil {
+			log.Printf("invalid outbox entry %s: %v", fi.Name(), err)
+			continue
+		}
+		o.entries[e.Message.Gid] = e
+	}
+	o.prune(time.Now())
+	return nil
+}
+
+func OpenOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		dir:     dir,
+		entries: make(map[string]*OutboxEntry),
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// 通过发件箱发送消息，返回的消息 Gid 可以用于查询发送状态
+// 发送失败时返回错误；如果返回的消息不为空，说明消息已经留在发件箱中，下次登录后重发
+// 没有设置 Outbox 时与 SendMessage 相同
+func (u *User) QueueMessage(gid string, m Message) (*ChatMessage, error) {
+	message := u.newChatMessage(gid, m)
+	if u.Outbox == nil {
+		if err := u.sendChatMessage(message); err != nil {
+			return nil, err
+		}
+		return message, nil
+	}
+
+	if err := u.Outbox.add(message); err != nil {
+		return nil, err
+	}
+
+	err := u.sendChatMessage(message)
+	u.Outbox.done(message.Gid, err)
+	if err != nil {
+		log.Printf("send message %s failed, queued: %s", message.Gid, err)
+	}
+	return message, err
+}
+
+// 重发发件箱中等待发送的消息
+func (u *User) FlushOutbox() error {
+	if u.Outbox == nil {
+		return nil
+	}
+
+	for _, message := range u.Outbox.pending() {
+		err := u.sendChatMessage(message)
+		u.Outbox.done(message.Gid, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package xxc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+type RateLimit struct {
+	Rate  float64 // 每秒允许的请求数，<= 0 表示不限制
+	Burst int     // 允许的突发请求数，最小为 1
+}
+
+// 发往服务器的请求限速配置
+type RateLimitConfig struct {
+	Global   RateLimit     // 所有请求
+	PerGroup RateLimit     // 每个会话的消息
+	Wait     bool          // 为 true 时排队等待，否则超过限制直接返回 ErrRateLimited
+	MaxWait  time.Duration // 排队最长等待时间，超过时返回 ErrRateLimited，0 表示不限
+}
+
+// 令牌桶
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// 取得一个令牌需要等待的时间
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+type rateLimiter struct {
+	config *RateLimitConfig
+
+	mu     sync.Mutex
+	global *tokenBucket
+	groups map[string]*tokenBucket
+}
+
+// 不受限制的请求，保证总是可以登录和退出
+var rateLimitExempt = map[string]bool{
+	"chat.login":  true,
+	"chat.logout": true,
+}
+
+func requestGroup(req *Request) string {
+	if params, ok := req.Params.(*messageParams); ok && len(params.Messages) > 0 {
+		return params.Messages[0].Cgid
+	}
+	return ""
+}
+
+func (l *rateLimiter) buckets(req *Request) []*tokenBucket {
+	var buckets []*tokenBucket
+	if l.global != nil {
+		buckets = append(buckets, l.global)
+	}
+
+	gid := requestGroup(req)
+	if gid == "" || l.config.PerGroup.Rate <= 0 {
+		return buckets
+	}
+
+	b := l.groups[gid]
+	if b == nil {
+		// 清理已经补满的桶，避免会话太多时无限增长
+		if len(l.groups) >= 1024 {
+			now := time.Now()
+			for k, v := range l.groups {
+				if v.refill(now); v.tokens >= v.burst {
+					delete(l.groups, k)
+				}
+			}
+		}
+		b = newTokenBucket(l.config.PerGroup)
+		l.groups[gid] = b
+	}
+	return append(buckets, b)
+}
+
+// 等待发送请求的许可
+func (l *rateLimiter) wait(req *Request) error {
+	if l == nil || rateLimitExempt[req.MethodName()] {
+		return nil
+	}
+
+	start := time.Now()
+	for {
+		l.mu.Lock()
+		var d time.Duration
+		buckets := l.buckets(req)
+		now := time.Now()
+		for _, b := range buckets {
+			if v := b.delay(now); v > d {
+				d = v
+			}
+		}
+
+		if d == 0 {
+			for _, b := range buckets {
+				b.take()
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if !l.config.Wait || (l.config.MaxWait > 0 && time.Since(start)+d > l.config.MaxWait) {
+			return ErrRateLimited
+		}
+		time.Sleep(d)
+	}
+}
+
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	if config == nil {
+		return nil
+	}
+	l := &rateLimiter{
+		config: config,
+		groups: make(map[string]*tokenBucket),
+	}
+	if config.Global.Rate > 0 {
+		l.global = newTokenBucket(config.Global)
+	}
+	return l
+}
@@ -0,0 +1,187 @@
+package xxc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 回放 Tracer 记录的会话
+// 服务器发来的帧按顺序交给客户端，遇到客户端应该发出的帧时，等客户端发出后再继续；
+// 客户端发出的帧与记录比较，不一致的记录下来，通过 Err 获取
+type ReplayTransport struct {
+	// 比较发出的帧时忽略的字段，如每次随机生成的消息 "gid"
+	IgnoreKeys []string
+
+	frames []*TraceFrame
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	used   []bool
+	pos    int // 第一个还没有用过的帧
+	closed bool
+	errs   []string
+}
+
+func (t *ReplayTransport) ReadFrame() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for {
+		for t.pos < len(t.frames) && t.used[t.pos] {
+			t.pos++
+		}
+		if t.closed || t.pos == len(t.frames) {
+			return nil, io.EOF
+		}
+
+		frame := t.frames[t.pos]
+		if frame.Direction == TraceIn {
+			t.used[t.pos] = true
+			t.pos++
+			return frame.Frame, nil
+		}
+		// 等待客户端发出记录中的帧
+		t.cond.Wait()
+	}
+}
+
+func (t *ReplayTransport) WriteFrame(data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return errors.New("replay transport closed")
+	}
+
+	index := -1
+	for i := t.pos; i < len(t.frames); i++ {
+		if !t.used[i] && t.frames[i].Direction == TraceOut {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return t.fail("unexpected frame: %s", data)
+	}
+
+	t.used[index] = true
+	t.cond.Broadcast()
+
+	want := t.frames[index].Frame
+	if !t.equal(want, redactFrame(data)) {
+		return t.fail("frame %d mismatch:\n\twant: %s\n\tgot:  %s", index, want, data)
+	}
+	return nil
+}
+
+func (t *ReplayTransport) fail(format string, a ...interface{}) error {
+	err := fmt.Sprintf(format, a...)
+	t.errs = append(t.errs, err)
+	return errors.New(err)
+}
+
+func (t *ReplayTransport) strip(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if containsString(t.IgnoreKeys, key) {
+				delete(v, key)
+			} else {
+				v[key] = t.strip(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = t.strip(value)
+		}
+	}
+	return v
+}
+
+func (t *ReplayTransport) equal(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return string(a) == string(b)
+	}
+	return reflect.DeepEqual(t.strip(va), t.strip(vb))
+}
+
+func (t *ReplayTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.cond.Broadcast()
+	return nil
+}
+
+// 所有记录的帧是否都已经回放
+func (t *ReplayTransport) Done() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, used := range t.used {
+		if !used {
+			return false
+		}
+	}
+	return true
+}
+
+// 回放过程中发现的不一致
+func (t *ReplayTransport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(t.errs, "\n"))
+}
+
+func NewReplayTransport(frames []*TraceFrame) *ReplayTransport {
+	t := &ReplayTransport{
+		frames: frames,
+		used:   make([]bool, len(frames)),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// 读取 Tracer 记录的跟踪文件
+func ReadTraceFrames(r io.Reader) ([]*TraceFrame, error) {
+	var frames []*TraceFrame
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		frame := &TraceFrame{}
+		if err := json.Unmarshal([]byte(line), frame); err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, scanner.Err()
+}
+
+func LoadReplayTransport(path string) (*ReplayTransport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	frames, err := ReadTraceFrames(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayTransport(frames), nil
+}
@@ -0,0 +1,457 @@
+package xxc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	replayLoginOut = `{"userID":0,"module":"chat","method":"login","params":["","bot","******","online"],"data":null}`
+	replayLoginIn  = `{"module":"chat","method":"login","result":"success","data":{"id":1,"account":"bot","realname":"Bot"}}`
+	replayUsersIn  = `{"module":"chat","method":"usergetlist","result":"success","data":[{"id":1,"account":"bot","realname":"Bot"},{"id":2,"account":"alice","realname":"Alice"}]}`
+	replayGroupsIn = `{"module":"chat","method":"getlist","result":"success","data":[{"gid":"1&2","type":"one2one","members":[1,2],"lastActiveTime":2000}]}`
+)
+
+func replayFrame(direction string, frame string) *TraceFrame {
+	return &TraceFrame{Direction: direction, Frame: json.RawMessage(frame)}
+}
+
+// 登录过程的帧，offline 是登录时推送的离线消息，放在会话列表之前，保证登录完成时已经处理
+func replayLoginFrames(offline string, groups string) []*TraceFrame {
+	frames := []*TraceFrame{
+		replayFrame(TraceOut, replayLoginOut),
+		replayFrame(TraceIn, replayLoginIn),
+		replayFrame(TraceIn, replayUsersIn),
+	}
+	if offline != "" {
+		frames = append(frames, replayFrame(TraceIn, offline))
+	}
+	return append(frames, replayFrame(TraceIn, groups))
+}
+
+type replayTransport interface {
+	Transport
+	Err() error
+}
+
+// 读到 method 的帧后等待 gate 关闭，用于控制返回到达的时机
+type gatedTransport struct {
+	*ReplayTransport
+	method string
+	gate   chan struct{}
+}
+
+func (t *gatedTransport) ReadFrame() ([]byte, error) {
+	frame, err := t.ReplayTransport.ReadFrame()
+	if err == nil && bytes.Contains(frame, []byte(`"method":"`+t.method+`"`)) {
+		<-t.gate
+	}
+	return frame, err
+}
+
+func replayUser(t *testing.T, transport replayTransport, opts *UserOptions) *User {
+	t.Helper()
+	t.Cleanup(func() { transport.Close() })
+
+	client := NewClientWithTransport(&ClientConfig{User: "bot", Password: "bot"}, transport)
+
+	type result struct {
+		user *User
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		user, err := CreateUserWithOptions(client, opts)
+		done <- result{user, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("create user failed: %s", r.err)
+		}
+		return r.user
+	case <-time.After(5 * time.Second):
+		t.Fatalf("create user timeout, replay errors: %v", transport.Err())
+	}
+	return nil
+}
+
+func checkReplay(t *testing.T, transport *ReplayTransport) {
+	t.Helper()
+	if err := transport.Err(); err != nil {
+		t.Fatalf("replay mismatch: %s", err)
+	}
+	if !transport.Done() {
+		t.Fatalf("not all frames replayed")
+	}
+}
+
+func TestReplayLogin(t *testing.T) {
+	offline := `{"module":"chat","method":"message","result":"success","data":[` +
+		`{"gid":"m1","cgid":"1&2","user":2,"date":1000,"type":"normal","contentType":"text","content":"hello"},` +
+		`{"gid":"m2","cgid":"1&2","user":1,"date":1001,"type":"normal","contentType":"text","content":"hi"}]}`
+	transport := NewReplayTransport(replayLoginFrames(offline, replayGroupsIn))
+	user := replayUser(t, transport, &UserOptions{})
+	checkReplay(t, transport)
+
+	if profile := user.GetProfile(); profile.Id != 1 || profile.Account != "bot" {
+		t.Errorf("profile = %+v", profile)
+	}
+	if alice := user.GetUserByAccount("alice"); alice == nil || alice.Id != 2 {
+		t.Errorf("alice = %+v", alice)
+	}
+	if group := user.QueryOne2OneGroup(2); group == nil || group.Gid != "1&2" {
+		t.Errorf("one2one group = %+v", group)
+	}
+	// 自己发的消息不算未读
+	if n := user.UnreadCount("1&2"); n != 1 {
+		t.Errorf("unread count = %d, want 1", n)
+	}
+	// 登录后凭证清零
+	for _, b := range user.Client.credential {
+		if b != 0 {
+			t.Fatalf("credential not cleared after login")
+		}
+	}
+}
+
+func TestReplayDispatch(t *testing.T) {
+	frames := replayLoginFrames("", replayGroupsIn)
+	frames = append(frames,
+		replayFrame(TraceOut, `{"userID":1,"module":"chat","method":"message","params":{"messages":[{"gid":"*","cgid":"1&2","user":1,"date":0,"type":"normal","contentType":"text","content":"ping"}]},"data":null}`),
+		replayFrame(TraceIn, `{"module":"chat","method":"message","result":"success","data":[{"gid":"m3","cgid":"1&2","user":2,"date":1002,"type":"normal","contentType":"text","content":"pong"}]}`),
+	)
+	transport := NewReplayTransport(frames)
+	transport.IgnoreKeys = []string{"gid"}
+	user := replayUser(t, transport, &UserOptions{})
+
+	received := make(chan *ChatMessage, 1)
+	user.HandleMessage(func(m *ChatMessage) {
+		received <- m
+	})
+	if err := user.SayToGroup("1&2", "ping"); err != nil {
+		t.Fatalf("say failed: %s", err)
+	}
+
+	select {
+	case m := <-received:
+		if m.Gid != "m3" || m.Content != "pong" {
+			t.Errorf("received %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message dispatched, replay errors: %v", transport.Err())
+	}
+	checkReplay(t, transport)
+}
+
+func TestReplayCatchUp(t *testing.T) {
+	groups := `{"module":"chat","method":"getlist","result":"success","data":[` +
+		`{"gid":"1&2","type":"one2one","members":[1,2],"lastActiveTime":2000},` +
+		`{"gid":"6a8e6a1c-0c3b-4b43-9d1c-2f6f7a0c1b2d","name":"old","type":"group","members":[1,2],"lastActiveTime":100}]}`
+	frames := replayLoginFrames("", groups)
+	frames = append(frames,
+		replayFrame(TraceOut, `{"userID":1,"module":"chat","method":"history","params":["1&2",50,1,0,false,1000],"data":null}`),
+		replayFrame(TraceIn, `{"module":"chat","method":"history","result":"success","data":[`+
+			`{"gid":"m1","cgid":"1&2","user":2,"date":1000,"type":"normal","contentType":"text","content":"seen"},`+
+			`{"gid":"m4","cgid":"1&2","user":2,"date":1500,"type":"normal","contentType":"text","content":"missed"}]}`),
+	)
+	transport := NewReplayTransport(frames)
+
+	// 上次登录处理过 m1，没有新消息的会话不拉取历史
+	tracker := NewMessageTracker()
+	tracker.created = time.Unix(500, 0)
+	tracker.track([]*ChatMessage{{Gid: "m1", Cgid: "1&2", User: 2, Date: NewTimestamp(time.Unix(1000, 0))}})
+
+	user := replayUser(t, transport, &UserOptions{Tracker: tracker})
+
+	var received []string
+	user.HandleMessage(func(m *ChatMessage) {
+		received = append(received, m.Gid)
+	})
+	if err := user.CatchUp(); err != nil {
+		t.Fatalf("catch up failed: %s", err)
+	}
+	checkReplay(t, transport)
+
+	if len(received) != 1 || received[0] != "m4" {
+		t.Errorf("received %v, want [m4]", received)
+	}
+	if last := tracker.LastMessage("1&2"); last == nil || last.Gid != "m4" {
+		t.Errorf("last message = %+v", last)
+	}
+}
+
+func TestReplayOutbox(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 上次登录没有发出去的消息
+	if err := outbox.add(&ChatMessage{Gid: "q1", Cgid: "1&2", User: 1, Type: "normal", ContentType: "text", Content: "queued"}); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := replayLoginFrames("", replayGroupsIn)
+	frames = append(frames,
+		replayFrame(TraceOut, `{"userID":1,"module":"chat","method":"message","params":{"messages":[{"gid":"q1","cgid":"1&2","user":1,"date":0,"type":"normal","contentType":"text","content":"queued"}]},"data":null}`),
+	)
+	transport := NewReplayTransport(frames)
+	replayUser(t, transport, &UserOptions{Outbox: outbox})
+	checkReplay(t, transport)
+
+	if e := outbox.Status("q1"); e == nil || e.Status != OutboxSent || e.Attempts != 1 {
+		t.Errorf("status = %+v", e)
+	}
+
+	// 状态保存在磁盘上
+	reopened, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := reopened.Status("q1"); e == nil || e.Status != OutboxSent {
+		t.Errorf("reopened status = %+v", e)
+	}
+	if l := reopened.pending(); len(l) != 0 {
+		t.Errorf("pending = %d, want 0", len(l))
+	}
+}
+
+func TestReplayOutboxSendFailed(t *testing.T) {
+	outbox, err := OpenOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有可以回放的帧，发送失败
+	transport := NewReplayTransport(replayLoginFrames("", replayGroupsIn))
+	user := replayUser(t, transport, &UserOptions{Outbox: outbox})
+	checkReplay(t, transport)
+
+	m, err := user.QueueMessage("1&2", Text("later"))
+	if err == nil {
+		t.Fatalf("queue message should fail")
+	}
+	if m == nil {
+		t.Fatalf("failed message should be queued")
+	}
+	if e := outbox.Status(m.Gid); e == nil || e.Status != OutboxPending || e.Attempts != 1 || e.Error == "" {
+		t.Errorf("status = %+v", e)
+	}
+
+	// SayToGroup 经过发件箱
+	if err := user.SayToGroup("1&2", "say"); err == nil {
+		t.Fatalf("say should fail")
+	}
+	if l := outbox.pending(); len(l) != 2 {
+		t.Errorf("pending = %d, want 2", len(l))
+	}
+
+	// SendMessage 不经过发件箱
+	if err := user.SendMessage("1&2", Text("direct")); err == nil {
+		t.Fatalf("send message should fail")
+	}
+	if l := outbox.pending(); len(l) != 2 {
+		t.Errorf("pending = %d, want 2", len(l))
+	}
+}
+
+const (
+	replayNoGroupsIn = `{"module":"chat","method":"getlist","result":"success","data":[]}`
+	replayCreateOut  = `{"userID":1,"module":"chat","method":"create","params":["1&2","","one2one",[1,2],0,false],"data":null}`
+)
+
+func TestReplayCreateOne2One(t *testing.T) {
+	frames := replayLoginFrames("", replayNoGroupsIn)
+	frames = append(frames,
+		replayFrame(TraceOut, replayCreateOut),
+		// 其他客户端创建的会话，不是这次创建的返回
+		replayFrame(TraceIn, `{"module":"chat","method":"create","result":"success","data":{"gid":"3&1","type":"one2one","members":[3,1]}}`),
+		// 服务器返回对方创建过的 "peer&me" 会话
+		replayFrame(TraceIn, `{"module":"chat","method":"create","result":"success","data":{"gid":"2&1","type":"one2one","members":[2,1]}}`),
+		replayFrame(TraceOut, `{"userID":1,"module":"chat","method":"message","params":{"messages":[{"gid":"*","cgid":"2&1","user":1,"date":0,"type":"normal","contentType":"text","content":"hi"}]},"data":null}`),
+	)
+	transport := NewReplayTransport(frames)
+	transport.IgnoreKeys = []string{"gid"}
+	user := replayUser(t, transport, &UserOptions{})
+
+	if err := user.SayToUser("alice", "hi"); err != nil {
+		t.Fatalf("say to user failed: %s", err)
+	}
+	checkReplay(t, transport)
+
+	if group := user.QueryOne2OneGroup(2); group == nil || group.Gid != "2&1" {
+		t.Errorf("one2one group = %+v", group)
+	}
+	if group := user.GetGroup("3&1"); group == nil {
+		t.Errorf("pushed group not cached")
+	}
+}
+
+func TestReplayCreateOne2OneConcurrent(t *testing.T) {
+	frames := replayLoginFrames("", replayNoGroupsIn)
+	frames = append(frames,
+		replayFrame(TraceOut, replayCreateOut),
+		replayFrame(TraceIn, `{"module":"chat","method":"create","result":"success","data":{"gid":"1&2","type":"one2one","members":[1,2]}}`),
+	)
+	transport := &gatedTransport{
+		ReplayTransport: NewReplayTransport(frames),
+		method:          "create",
+		gate:            make(chan struct{}),
+	}
+	user := replayUser(t, transport, &UserOptions{})
+
+	creating := func() bool {
+		user.createMutex.Lock()
+		defer user.createMutex.Unlock()
+		return user.creating[2] != nil
+	}
+
+	var wg sync.WaitGroup
+	groups := make([]*ChatGroup, 2)
+	errs := make([]error, 2)
+	create := func(i int) {
+		defer wg.Done()
+		groups[i], errs[i] = user.CreateOne2OneGroup(2)
+	}
+
+	// 第一次创建的返回到达前发起第二次，第二次等待第一次的结果，不再发送请求
+	wg.Add(2)
+	go create(0)
+	for !creating() {
+		time.Sleep(time.Millisecond)
+	}
+	go create(1)
+	time.Sleep(50 * time.Millisecond)
+	close(transport.gate)
+	wg.Wait()
+
+	checkReplay(t, transport.ReplayTransport)
+	for i := range groups {
+		if errs[i] != nil || groups[i] == nil || groups[i].Gid != "1&2" {
+			t.Errorf("create %d: group = %+v, err = %v", i, groups[i], errs[i])
+		}
+	}
+}
+
+func TestReplayCallTimeout(t *testing.T) {
+	frames := replayLoginFrames("", replayNoGroupsIn)
+	frames = append(frames,
+		replayFrame(TraceOut, replayCreateOut),
+		replayFrame(TraceIn, `{"module":"chat","method":"create","result":"success","data":{"gid":"1&2","type":"one2one","members":[1,2]}}`),
+	)
+	// 返回一直不到达
+	transport := &gatedTransport{
+		ReplayTransport: NewReplayTransport(frames),
+		method:          "create",
+		gate:            make(chan struct{}),
+	}
+	t.Cleanup(func() { close(transport.gate) })
+	user := replayUser(t, transport, &UserOptions{})
+	user.Client.wsClient.callTimeout = 100 * time.Millisecond
+
+	_, err := user.CreateOne2OneGroup(2)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+}
+
+func TestReplayCatchUpAfterLiveMessage(t *testing.T) {
+	frames := replayLoginFrames("", replayGroupsIn)
+	frames = append(frames,
+		// CatchUp 之前收到的新消息
+		replayFrame(TraceIn, `{"module":"chat","method":"message","result":"success","data":[{"gid":"m5","cgid":"1&2","user":2,"date":1800,"type":"normal","contentType":"text","content":"live"}]}`),
+		// 仍然从登录前最后处理的 m1 开始补齐
+		replayFrame(TraceOut, `{"userID":1,"module":"chat","method":"history","params":["1&2",50,1,0,false,1000],"data":null}`),
+		replayFrame(TraceIn, `{"module":"chat","method":"history","result":"success","data":[`+
+			`{"gid":"m1","cgid":"1&2","user":2,"date":1000,"type":"normal","contentType":"text","content":"seen"},`+
+			`{"gid":"m4","cgid":"1&2","user":2,"date":1500,"type":"normal","contentType":"text","content":"missed"},`+
+			`{"gid":"m5","cgid":"1&2","user":2,"date":1800,"type":"normal","contentType":"text","content":"live"}]}`),
+	)
+	transport := &gatedTransport{
+		ReplayTransport: NewReplayTransport(frames),
+		method:          "message",
+		gate:            make(chan struct{}),
+	}
+
+	tracker := NewMessageTracker()
+	tracker.track([]*ChatMessage{{Gid: "m1", Cgid: "1&2", User: 2, Date: NewTimestamp(time.Unix(1000, 0))}})
+	user := replayUser(t, transport, &UserOptions{Tracker: tracker})
+
+	received := make(chan string, 4)
+	user.HandleMessage(func(m *ChatMessage) {
+		received <- m.Gid
+	})
+	close(transport.gate)
+	select {
+	case gid := <-received:
+		if gid != "m5" {
+			t.Fatalf("received %s, want m5", gid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("live message not dispatched")
+	}
+
+	if err := user.CatchUp(); err != nil {
+		t.Fatalf("catch up failed: %s", err)
+	}
+	checkReplay(t, transport.ReplayTransport)
+
+	close(received)
+	var rest []string
+	for gid := range received {
+		rest = append(rest, gid)
+	}
+	if len(rest) != 1 || rest[0] != "m4" {
+		t.Errorf("caught up %v, want [m4]", rest)
+	}
+}
+
+func TestReplayProbeUnsupported(t *testing.T) {
+	frames := replayLoginFrames("", replayGroupsIn)
+	frames = append(frames,
+		replayFrame(TraceOut, `{"userID":1,"module":"chat","method":"deptgetlist","params":null,"data":null}`),
+		replayFrame(TraceIn, `{"module":"chat","method":"deptgetlist","result":"fail","message":"error"}`),
+		// 服务器忽略 startDate，返回了之前的消息
+		replayFrame(TraceOut, `{"userID":1,"module":"chat","method":"history","params":["1&2",50,1,0,false,1000],"data":null}`),
+		replayFrame(TraceIn, `{"module":"chat","method":"history","result":"success","data":[`+
+			`{"gid":"m2","cgid":"1&2","user":2,"date":1500,"type":"normal","contentType":"text","content":"new"},`+
+			`{"gid":"m1","cgid":"1&2","user":2,"date":900,"type":"normal","contentType":"text","content":"old"}]}`),
+		replayFrame(TraceOut, `{"userID":1,"module":"chat","method":"history","params":["1&2",50,1,0,false,0],"data":null}`),
+		replayFrame(TraceIn, `{"module":"chat","method":"history","result":"success","data":[]}`),
+	)
+	transport := NewReplayTransport(frames)
+	user := replayUser(t, transport, &UserOptions{})
+
+	if !user.Client.Supports(FeatureDeptList) {
+		t.Fatalf("dept list should be supported before probing")
+	}
+	user.ReloadDeptList()
+	if user.Client.Supports(FeatureDeptList) {
+		t.Errorf("dept list should be unsupported after a failed reply")
+	}
+	// 不再发送请求，否则回放报告多余的帧
+	user.ReloadDeptList()
+
+	since := NewTimestamp(time.Unix(1000, 0))
+	l, err := user.fetchHistorySince("1&2", since)
+	if err != nil {
+		t.Fatalf("fetch history failed: %s", err)
+	}
+	if len(l) != 1 || l[0].Gid != "m2" {
+		t.Errorf("history = %v, want [m2]", l)
+	}
+	if user.Client.Supports(FeatureHistoryStartDate) {
+		t.Errorf("startDate should be unsupported after the server ignored it")
+	}
+	// 之后不再发送 startDate
+	if _, err := user.fetchHistorySince("1&2", since); err != nil {
+		t.Fatalf("fetch history failed: %s", err)
+	}
+	checkReplay(t, transport)
+}
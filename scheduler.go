@@ -0,0 +1,454 @@
+package xxc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// 定时消息，Cron 和 At 只能设置一个
+type ScheduledMessage struct {
+	Id       string     `json:"id"`
+	Cron     string     `json:"cron,omitempty"` // cron 表达式，见 ParseCron
+	At       *time.Time `json:"at,omitempty"`   // 一次性发送的时间
+	Groups   []string   `json:"groups,omitempty"`
+	Users    []string   `json:"users,omitempty"` // 用户账号，私聊发送
+	Template string     `json:"template"`        // text/template 模板，见 ScheduleData
+
+	Last *time.Time `json:"last,omitempty"` // 最后一次发送的时间
+}
+
+// 渲染模板的数据
+type ScheduleData struct {
+	Now      time.Time // 计划发送的时间
+	Schedule *ScheduledMessage
+}
+
+type scheduleEntry struct {
+	*ScheduledMessage
+	cron  *CronSchedule
+	tmpl  *template.Template
+	added time.Time
+
+	// 部分发送失败的一次发送，retry 之后重发给 sent 以外的目标
+	pending time.Time
+	sent    map[string]bool
+	retry   time.Time
+}
+
+// 下一次发送的时间，不会再发送时返回零值
+func (e *scheduleEntry) next() time.Time {
+	if e.cron == nil {
+		if e.Last != nil && !e.Last.Before(*e.At) {
+			return time.Time{}
+		}
+		return *e.At
+	}
+
+	base := e.added
+	if e.Last != nil {
+		base = *e.Last
+	}
+	return e.cron.Next(base)
+}
+
+func newScheduleEntry(m *ScheduledMessage) (*scheduleEntry, error) {
+	if m.Id == "" {
+		return nil, errors.New("schedule without id")
+	}
+	if (m.Cron == "") == (m.At == nil) {
+		return nil, fmt.Errorf("schedule %s: exactly one of cron and at is required", m.Id)
+	}
+	if len(m.Groups) == 0 && len(m.Users) == 0 {
+		return nil, fmt.Errorf("schedule %s: no groups or users", m.Id)
+	}
+
+	e := &scheduleEntry{
+		ScheduledMessage: m,
+		added:            time.Now(),
+	}
+	if m.Cron != "" {
+		cron, err := ParseCron(m.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %s", m.Id, err)
+		}
+		e.cron = cron
+	}
+
+	tmpl, err := template.New(m.Id).Parse(m.Template)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: %s", m.Id, err)
+	}
+	e.tmpl = tmpl
+	return e, nil
+}
+
+// 定时发送消息
+// 发送记录保存在文件中，重启后不会重复发送一次性消息；
+// 停机或离线期间错过的消息在恢复后补发一次，超过 MaxDelay 的不再补发；
+// 发送失败的目标每隔 RetryDelay 重发一次，直到超过 MaxDelay
+type Scheduler struct {
+	MaxDelay   time.Duration // 错过发送时间多久以内仍然补发，默认 1 小时
+	RetryDelay time.Duration // 发送失败后多久重发，默认 1 分钟
+
+	path string
+
+	mu      sync.Mutex
+	entries map[string]*scheduleEntry
+	user    *User
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (s *Scheduler) maxDelay() time.Duration {
+	if s.MaxDelay <= 0 {
+		return time.Hour
+	}
+	return s.MaxDelay
+}
+
+func (s *Scheduler) retryDelay() time.Duration {
+	if s.RetryDelay <= 0 {
+		return time.Minute
+	}
+	return s.RetryDelay
+}
+
+func (s *Scheduler) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+
+	l := make([]*ScheduledMessage, 0, len(s.entries))
+	for _, e := range s.entries {
+		l = append(l, e.ScheduledMessage)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Id < l[j].Id
+	})
+
+	v, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, v, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *Scheduler) save() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveLocked(); err != nil {
+		log.Printf("save schedules failed: %s", err)
+	}
+}
+
+// 添加或替换定时消息，替换时保留发送记录
+func (s *Scheduler) Add(m *ScheduledMessage) error {
+	e, err := newScheduleEntry(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old := s.entries[m.Id]; old != nil {
+		if m.Last == nil {
+			m.Last = old.Last
+		}
+		e.added = old.added
+	}
+	s.entries[m.Id] = e
+	return s.saveLocked()
+}
+
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries[id] == nil {
+		return fmt.Errorf("%s is not a valid schedule id", id)
+	}
+	delete(s.entries, id)
+	return s.saveLocked()
+}
+
+// 用配置中的定时消息替换现有的定时消息，保留相同 Id 的发送记录
+func (s *Scheduler) Sync(l []*ScheduledMessage) error {
+	entries := make(map[string]*scheduleEntry)
+	for _, m := range l {
+		e, err := newScheduleEntry(m)
+		if err != nil {
+			return err
+		}
+		if entries[m.Id] != nil {
+			return fmt.Errorf("duplicate schedule id: %s", m.Id)
+		}
+		entries[m.Id] = e
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, e := range entries {
+		if old := s.entries[id]; old != nil {
+			if e.Last == nil {
+				e.Last = old.Last
+			}
+			e.added = old.added
+		}
+	}
+	s.entries = entries
+	return s.saveLocked()
+}
+
+// 所有定时消息，按 Id 排序
+func (s *Scheduler) List() []*ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := make([]*ScheduledMessage, 0, len(s.entries))
+	for _, e := range s.entries {
+		v := *e.ScheduledMessage
+		l = append(l, &v)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Id < l[j].Id
+	})
+	return l
+}
+
+// 下一次发送的时间，不会再发送时返回零值
+func (s *Scheduler) Next(id string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entries[id]
+	if e == nil {
+		return time.Time{}
+	}
+	return e.next()
+}
+
+// 设置发送消息的用户，离线时设置为 nil，期间到期的消息在上线后发送
+func (s *Scheduler) SetUser(user *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// 发送消息，已经进入发件箱的消息由发件箱重发，不再算作失败，避免重复发送
+func queue(user *User, gid string, content string) error {
+	m, err := user.QueueMessage(gid, Text(content))
+	if err != nil && m != nil {
+		log.Printf("send message %s to %s failed, left in outbox: %s", m.Gid, gid, err)
+		return nil
+	}
+	return err
+}
+
+// 发送给 sent 以外的目标，返回发送成功的目标
+func (s *Scheduler) send(user *User, e *scheduleEntry, due time.Time, sent map[string]bool) (map[string]bool, error) {
+	var buf bytes.Buffer
+	if err := e.tmpl.Execute(&buf, &ScheduleData{Now: due, Schedule: e.ScheduledMessage}); err != nil {
+		return sent, err
+	}
+	content := buf.String()
+
+	done := make(map[string]bool)
+	for target := range sent {
+		done[target] = true
+	}
+
+	var lastErr error
+	for _, name := range e.Groups {
+		target := "group:" + name
+		if done[target] {
+			continue
+		}
+		group, err := user.ResolveGroup(name)
+		if err == nil {
+			err = queue(user, group.Gid, content)
+		}
+		if err != nil {
+			log.Printf("schedule %s: send to group %s failed: %s", e.Id, name, err)
+			lastErr = err
+			continue
+		}
+		done[target] = true
+	}
+	for _, account := range e.Users {
+		target := "user:" + account
+		if done[target] {
+			continue
+		}
+		profile := user.GetUserByAccount(account)
+		if profile == nil {
+			err := fmt.Errorf("%s is not a valid account", account)
+			log.Printf("schedule %s: send to user %s failed: %s", e.Id, account, err)
+			lastErr = err
+			continue
+		}
+		group, err := user.CreateOne2OneGroup(profile.Id)
+		if err == nil {
+			err = queue(user, group.Gid, content)
+		}
+		if err != nil {
+			log.Printf("schedule %s: send to user %s failed: %s", e.Id, account, err)
+			lastErr = err
+			continue
+		}
+		done[target] = true
+	}
+	return done, lastErr
+}
+
+// 一次到期的发送
+type scheduleJob struct {
+	entry *scheduleEntry
+	due   time.Time
+	sent  map[string]bool
+}
+
+// 到期的消息，包括到了重发时间的消息；太晚的不再发送，记录为已经发送
+// changed 表示有没有需要保存的发送记录
+func (s *Scheduler) due(now time.Time) (user *User, jobs []*scheduleJob, changed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.user == nil {
+		return
+	}
+
+	for _, e := range s.entries {
+		next, sent := e.next(), map[string]bool(nil)
+		if !e.pending.IsZero() {
+			if now.Before(e.retry) {
+				continue
+			}
+			next, sent = e.pending, e.sent
+		}
+		if next.IsZero() || next.After(now) {
+			continue
+		}
+
+		if now.Sub(next) > s.maxDelay() {
+			log.Printf("schedule %s: skip %s, too late", e.Id, next.Format(time.RFC3339))
+			last := now
+			e.Last = &last
+			e.pending, e.sent, e.retry = time.Time{}, nil, time.Time{}
+			changed = true
+			continue
+		}
+		jobs = append(jobs, &scheduleJob{entry: e, due: next, sent: sent})
+	}
+	return s.user, jobs, changed
+}
+
+// 记录发送结果，全部发送成功后才记录为已经发送，否则等待重发
+func (s *Scheduler) done(job *scheduleJob, now time.Time, sent map[string]bool, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := job.entry
+	if err != nil {
+		e.pending, e.sent, e.retry = job.due, sent, now.Add(s.retryDelay())
+		return false
+	}
+	last := now
+	e.Last = &last
+	e.pending, e.sent, e.retry = time.Time{}, nil, time.Time{}
+	return true
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	user, jobs, changed := s.due(now)
+
+	for _, job := range jobs {
+		sent, err := s.send(user, job.entry, job.due, job.sent)
+		if err != nil {
+			log.Printf("schedule %s failed, retry in %s: %s", job.entry.Id, s.retryDelay(), err)
+		}
+		if s.done(job, now, sent, err) {
+			changed = true
+		}
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// 检查并发送到期的消息，直到调用 Stop
+func (s *Scheduler) Run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.tick(now)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// 读取 json 格式的定时消息列表
+func LoadScheduledMessages(path string) ([]*ScheduledMessage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var l []*ScheduledMessage
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return l, nil
+}
+
+// 创建 Scheduler，定时消息及发送记录保存在 path 中，path 为空时不保存
+func OpenScheduler(path string) (*Scheduler, error) {
+	s := &Scheduler{
+		path:    path,
+		entries: make(map[string]*scheduleEntry),
+		stop:    make(chan struct{}),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	l, err := LoadScheduledMessages(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range l {
+		e, err := newScheduleEntry(m)
+		if err != nil {
+			log.Printf("invalid schedule: %s", err)
+			continue
+		}
+		s.entries[m.Id] = e
+	}
+	return s, nil
+}
@@ -0,0 +1,129 @@
+package xxc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 服务器版本，如 "1.4.0"、"2.5.7.beta"
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	Pre   string // 预发布标记，如 beta
+}
+
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if s == "" {
+		return v, fmt.Errorf("invalid version: %q", s)
+	}
+
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '.' || r == '-' || r == '+'
+	})
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		if i >= len(nums) {
+			v.Pre = strings.Join(parts[i:], ".")
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			if i == 0 {
+				return v, fmt.Errorf("invalid version: %q", s)
+			}
+			v.Pre = strings.Join(parts[i:], ".")
+			break
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// 比较版本，返回 -1, 0, 1；预发布版本小于正式版本
+func (v Version) Compare(o Version) int {
+	a := []int{v.Major, v.Minor, v.Patch}
+	b := []int{o.Major, o.Minor, o.Patch}
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	case v.Pre < o.Pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "." + v.Pre
+	}
+	return s
+}
+
+// 不是所有服务器都支持的功能
+const (
+	FeatureHistoryStartDate = "history.startDate" // chat.history 支持按开始时间获取
+	FeatureDeptList         = "deptgetlist"       // chat.deptgetlist
+	FeatureReadReceipt      = "setmessagesread"   // chat.setmessagesread
+)
+
+// 通过方法是否返回失败探测的功能，见 Client.Supports
+// history.startDate 不支持时服务器忽略该参数，不会返回失败，在 fetchHistorySince 中按返回的消息探测
+var featureMethods = map[string]string{
+	"chat.deptgetlist":     FeatureDeptList,
+	"chat.setmessagesread": FeatureReadReceipt,
+}
+
+// 保留 serverInfo 返回的所有字段
+func (sc *ServerConfig) UnmarshalJSON(data []byte) error {
+	type serverConfig ServerConfig
+	if err := json.Unmarshal(data, (*serverConfig)(sc)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &sc.Fields)
+}
+
+// serverInfo 返回的字段，不存在时返回 false
+func (sc *ServerConfig) Field(name string, v interface{}) bool {
+	raw, ok := sc.Fields[name]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+// 服务器版本，无法解析时 ok 为 false
+func (sc *ServerConfig) ParsedVersion() (v Version, ok bool) {
+	v, err := ParseVersion(sc.Version)
+	return v, err == nil
+}
+
+// 服务器是否声明支持某个功能
+// 服务器在 serverInfo 中声明了 features 时以声明为准；
+// 没有声明时认为支持，各功能需要的服务器版本没有可靠的来源，不按版本猜测，
+// 由 Client.Supports 在调用失败后探测
+func (sc *ServerConfig) Supports(feature string) bool {
+	var features []string
+	if sc.Field("features", &features) {
+		return containsString(features, feature)
+	}
+	return true
+}
@@ -0,0 +1,249 @@
+package xxc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 消息查询条件
+type MessageQuery struct {
+	Cgid string    // 会话gid，空表示所有会话
+	User int       // 发送者ID，0 表示所有用户
+	From time.Time // 起始时间(包含)，零值表示不限
+	To   time.Time // 结束时间(不包含)，零值表示不限
+}
+
+func (q *MessageQuery) match(m *ChatMessage) bool {
+	if q.Cgid != "" && q.Cgid != m.Cgid {
+		return false
+	}
+	if q.User != 0 && q.User != m.User {
+		return false
+	}
+	if !q.From.IsZero() && m.Date.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !m.Date.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// 本地存储，记录收到的消息、会话和用户变化
+type Store interface {
+	SaveMessages(messages []*ChatMessage) error
+	SaveGroups(groups []*ChatGroup) error
+	SaveUsers(users []*UserProfile) error
+
+	// 按时间顺序返回符合条件的消息，相同 Gid 的消息只返回一条
+	QueryMessages(q *MessageQuery) ([]*ChatMessage, error)
+}
+
+type fileRecord struct {
+	Time    int64        `json:"time"`
+	Message *ChatMessage `json:"message,omitempty"`
+	Group   *ChatGroup   `json:"group,omitempty"`
+	User    *UserProfile `json:"user,omitempty"`
+}
+
+// 基于 jsonl 文件的存储，所有文件只追加不修改:
+//
+//	dir/messages/<gid>.jsonl  每个会话一个消息文件
+//	dir/groups.jsonl          会话变化
+//	dir/users.jsonl           用户变化
+type FileStore struct {
+	dir string
+
+	mu     sync.Mutex
+	groups map[string]string // gid -> 最后一次记录的内容，内容不变时不重复记录
+	users  map[int]string
+}
+
+func (s *FileStore) messagePath(gid string) string {
+	return filepath.Join(s.dir, "messages", url.PathEscape(gid)+".jsonl")
+}
+
+func (s *FileStore) append(path string, records []*fileRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (s *FileStore) read(path string, f func(*fileRecord)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		r := &fileRecord{}
+		// 忽略写了一半的行
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			continue
+		}
+		f(r)
+	}
+	return scanner.Err()
+}
+
+func (s *FileStore) SaveMessages(messages []*ChatMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	byGroup := make(map[string][]*fileRecord)
+	for _, m := range messages {
+		byGroup[m.Cgid] = append(byGroup[m.Cgid], &fileRecord{Time: now, Message: m})
+	}
+	for gid, records := range byGroup {
+		if err := s.append(s.messagePath(gid), records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) SaveGroups(groups []*ChatGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	var records []*fileRecord
+	for _, g := range groups {
+		v, _ := json.Marshal(g)
+		if s.groups[g.Gid] == string(v) {
+			continue
+		}
+		s.groups[g.Gid] = string(v)
+		records = append(records, &fileRecord{Time: now, Group: g})
+	}
+	return s.append(filepath.Join(s.dir, "groups.jsonl"), records)
+}
+
+func (s *FileStore) SaveUsers(users []*UserProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	var records []*fileRecord
+	for _, u := range users {
+		v, _ := json.Marshal(u)
+		if s.users[u.Id] == string(v) {
+			continue
+		}
+		s.users[u.Id] = string(v)
+		records = append(records, &fileRecord{Time: now, User: u})
+	}
+	return s.append(filepath.Join(s.dir, "users.jsonl"), records)
+}
+
+func (s *FileStore) QueryMessages(q *MessageQuery) ([]*ChatMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var paths []string
+	if q.Cgid != "" {
+		paths = []string{s.messagePath(q.Cgid)}
+	} else {
+		files, err := ioutil.ReadDir(filepath.Join(s.dir, "messages"))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if strings.HasSuffix(fi.Name(), ".jsonl") {
+				paths = append(paths, filepath.Join(s.dir, "messages", fi.Name()))
+			}
+		}
+	}
+
+	index := make(map[string]int)
+	var messages []*ChatMessage
+	for _, path := range paths {
+		err := s.read(path, func(r *fileRecord) {
+			m := r.Message
+			if m == nil || !q.match(m) {
+				return
+			}
+			// 同一条消息可能被记录多次，以最后一次为准
+			if i, ok := index[m.Gid]; ok {
+				messages[i] = m
+				return
+			}
+			index[m.Gid] = len(messages)
+			messages = append(messages, m)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Date.Before(messages[j].Date.Time)
+	})
+	return messages, nil
+}
+
+// 加载已有记录，恢复会话和用户的最后状态
+func (s *FileStore) load() error {
+	err := s.read(filepath.Join(s.dir, "groups.jsonl"), func(r *fileRecord) {
+		if r.Group != nil {
+			v, _ := json.Marshal(r.Group)
+			s.groups[r.Group.Gid] = string(v)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.read(filepath.Join(s.dir, "users.jsonl"), func(r *fileRecord) {
+		if r.User != nil {
+			v, _ := json.Marshal(r.User)
+			s.users[r.User.Id] = string(v)
+		}
+	})
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "messages"), 0755); err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		dir:    dir,
+		groups: make(map[string]string),
+		users:  make(map[int]string),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,144 @@
+package xxc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TraceIn  = "in"  // 服务器发来的帧
+	TraceOut = "out" // 发往服务器的帧
+)
+
+const redacted = "******"
+
+// 跟踪文件中的一行
+type TraceFrame struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"dir"`
+	Frame     json.RawMessage `json:"frame"`
+}
+
+// 把解密后的协议帧记录到 jsonl 文件，密码和 token 会被隐去
+// 关闭后记录的帧会被丢弃
+type Tracer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	c      io.Closer
+	closed bool
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "password") || strings.Contains(key, "token")
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSecretKey(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+	return v
+}
+
+// 隐去帧中的密码和 token
+// chat.login 的参数是按位置排列的，第三个参数是密码
+func redactFrame(data []byte) json.RawMessage {
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		// 不是 json，按字符串记录
+		s, _ := json.Marshal(string(data))
+		return s
+	}
+
+	if v["module"] == "chat" && v["method"] == "login" {
+		if params, ok := v["params"].([]interface{}); ok && len(params) > 2 {
+			params[2] = redacted
+		}
+	}
+
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		s, _ := json.Marshal(string(data))
+		return s
+	}
+	return out
+}
+
+func (t *Tracer) Trace(direction string, data []byte) {
+	frame := &TraceFrame{
+		Time:      time.Now(),
+		Direction: direction,
+		Frame:     redactFrame(data),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	v, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("trace frame failed: %s", err)
+		return
+	}
+	t.w.Write(v)
+	t.w.WriteByte('\n')
+	// 进程异常退出时，尽量不丢帧
+	if err := t.w.Flush(); err != nil {
+		log.Printf("trace frame failed: %s", err)
+	}
+}
+
+func (t *Tracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	err := t.w.Flush()
+	if t.c != nil {
+		if cerr := t.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func NewTracer(w io.Writer) *Tracer {
+	t := &Tracer{
+		w: bufio.NewWriter(w),
+	}
+	if c, ok := w.(io.Closer); ok {
+		t.c = c
+	}
+	return t
+}
+
+// 以追加方式打开跟踪文件
+func OpenTracer(path string) (*Tracer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewTracer(f), nil
+}
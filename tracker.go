@@ -0,0 +1,173 @@
+package xxc
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxTrackedMessages = 10000 // 最多记住多少条消息的 gid 用于去重
+	historyPageSize    = 50
+	maxHistoryPages    = 20 // 每个会话最多补齐的页数
+)
+
+// 记录每个会话最后处理的消息，断线重连后据此补齐中间漏掉的消息
+// 同一个 MessageTracker 可以在多次登录创建的 User 之间共享
+type MessageTracker struct {
+	created time.Time // 没有处理过消息的会话从此时开始补齐
+
+	mu       sync.Mutex
+	last     map[string]*ChatMessage // cgid -> 最后处理的消息
+	seen     map[string]bool         // 已处理消息的 gid
+	seenList []string
+}
+
+// 过滤掉已经处理过的消息，记录剩下的消息
+func (t *MessageTracker) track(messages []*ChatMessage) []*ChatMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.seen == nil {
+		t.seen = make(map[string]bool)
+		t.last = make(map[string]*ChatMessage)
+	}
+
+	var l []*ChatMessage
+	for _, m := range messages {
+		if t.seen[m.Gid] {
+			continue
+		}
+		t.seen[m.Gid] = true
+		t.seenList = append(t.seenList, m.Gid)
+		l = append(l, m)
+
+		if last := t.last[m.Cgid]; last == nil || !m.Date.Before(last.Date.Time) {
+			t.last[m.Cgid] = m
+		}
+	}
+
+	if n := len(t.seenList) - maxTrackedMessages; n > 0 {
+		for _, gid := range t.seenList[:n] {
+			delete(t.seen, gid)
+		}
+		t.seenList = append([]string(nil), t.seenList[n:]...)
+	}
+	return l
+}
+
+// 会话最后处理的消息
+func (t *MessageTracker) LastMessage(cgid string) *ChatMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last[cgid]
+}
+
+func (t *MessageTracker) lastMessages() map[string]*ChatMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m := make(map[string]*ChatMessage, len(t.last))
+	for cgid, last := range t.last {
+		m[cgid] = last
+	}
+	return m
+}
+
+func NewMessageTracker() *MessageTracker {
+	return &MessageTracker{created: time.Now()}
+}
+
+// 拉取会话从 since 开始的历史消息，按时间顺序返回
+func (u *User) fetchHistorySince(cgid string, since Timestamp) ([]*ChatMessage, error) {
+	// 不支持按时间获取的服务器会忽略 startDate，从最新的消息往前翻页，
+	// 所以总是在本地按时间过滤，翻到 since 之前为止
+	byDate := u.Client.Supports(FeatureHistoryStartDate)
+
+	var messages []*ChatMessage
+	for page := 1; page <= maxHistoryPages; page++ {
+		historyAPI := &HistoryAPI{
+			Gid:        cgid,
+			RecPerPage: historyPageSize,
+			PageID:     page,
+		}
+		if byDate {
+			historyAPI.StartDate = since
+		}
+
+		resp, err := u.Client.Call(NewChatRequest(u.profile.Id, historyAPI))
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := historyAPI.Decode(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		reached := false
+		for _, m := range l {
+			if m.Date.Before(since.Time) {
+				// 返回了 startDate 之前的消息，说明服务器忽略了 startDate
+				if byDate {
+					u.Client.setUnsupported(FeatureHistoryStartDate)
+				}
+				reached = true
+				continue
+			}
+			messages = append(messages, m)
+		}
+
+		if reached || len(l) < historyPageSize {
+			break
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].Date.Equal(messages[j].Date.Time) {
+			return messages[i].Date.Before(messages[j].Date.Time)
+		}
+		return messages[i].Id < messages[j].Id
+	})
+	return messages, nil
+}
+
+// 补齐断线期间漏掉的消息
+// 对每个会话，从登录前 Tracker 中最后处理的消息开始拉取历史消息，没有处理过消息的会话从 Tracker 创建时开始，
+// 按顺序经过正常的消息处理流程，已经处理过的消息会被忽略；
+// 登录后收到的新消息不影响补齐的起点，避免跳过断线期间的消息
+// 一个会话失败时继续补齐其他会话，返回最后一个错误
+// 应该在注册好消息处理函数后调用，消息处理函数的限制见 HandleMessage
+func (u *User) CatchUp() error {
+	return u.catchUp(u.catchUpFrom)
+}
+
+func (u *User) catchUp(lasts map[string]*ChatMessage) error {
+	var lastErr error
+	for _, group := range u.FindGroups(&GroupFilter{}) {
+		since := NewTimestamp(u.Tracker.created)
+		if last := lasts[group.Gid]; last != nil {
+			since = last.Date
+		} else if since.IsZero() {
+			continue
+		}
+		// 之后没有新消息
+		if !group.LastActiveTime.IsZero() && group.LastActiveTime.Before(since.Time) {
+			continue
+		}
+
+		messages, err := u.fetchHistorySince(group.Gid, since)
+		if err != nil {
+			log.Printf("CatchUp: g<%s> failed: %s", group.Gid, err)
+			lastErr = err
+			continue
+		}
+
+		if Verbose {
+			log.Printf("CatchUp: g<%s> %d messages since %s", group.Gid, len(messages), since)
+		}
+		u.dispatchMessages(messages)
+	}
+	return lastErr
+}
@@ -0,0 +1,126 @@
+package xxc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 服务器返回的时间，可能是整数、数字字符串或者空字符串
+// 序列化为 unix 秒数，解析时是毫秒的仍然序列化为毫秒；零值序列化为 0
+type Timestamp struct {
+	time.Time
+	millis bool // 解析自毫秒数
+}
+
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Time: t}
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("0"), nil
+	}
+	if t.millis {
+		return []byte(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)), nil
+	}
+	return []byte(strconv.FormatInt(t.Unix(), 10)), nil
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	s := string(bytes.TrimSpace(data))
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		s = strings.TrimSpace(s)
+	}
+	t.millis = false
+	if s == "" || s == "0" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		// 兼容 "2006-01-02 15:04:05" 格式
+		tm, err2 := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+		if err2 != nil {
+			return fmt.Errorf("invalid timestamp: %s", s)
+		}
+		t.Time = tm
+		return nil
+	}
+
+	// 超过这个值认为是毫秒
+	if v > 1e12 {
+		t.Time = time.Unix(0, int64(v*float64(time.Millisecond)))
+		t.millis = true
+	} else {
+		t.Time = time.Unix(int64(v), 0)
+	}
+	return nil
+}
+
+// 逗号分隔的用户ID列表，如 "1,2,3"，也兼容数组
+// 序列化为逗号分隔的字符串
+type IntList []int
+
+func (l IntList) MarshalJSON() ([]byte, error) {
+	s := make([]string, len(l))
+	for i, v := range l {
+		s[i] = strconv.Itoa(v)
+	}
+	return json.Marshal(strings.Join(s, ","))
+}
+
+func (l *IntList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*l = nil
+		return nil
+	}
+
+	var items []string
+	if bytes.HasPrefix(data, []byte("[")) {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		for _, r := range raw {
+			items = append(items, strings.Trim(string(r), `"`))
+		}
+	} else {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			// 单个整数
+			s = string(data)
+		}
+		items = strings.Split(s, ",")
+	}
+
+	var v IntList
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return fmt.Errorf("invalid int list item: %s", item)
+		}
+		v = append(v, n)
+	}
+	*l = v
+	return nil
+}
+
+func (l IntList) Contains(v int) bool {
+	return containsInt(l, v)
+}
@@ -0,0 +1,119 @@
+package xxc
+
+import (
+	"sort"
+	"strings"
+)
+
+const maxUnreadGroups = 1000 // 最多记录多少个会话的未读消息，超过时丢弃最久没有消息的会话
+
+// 有未读消息的会话
+type UnreadGroup struct {
+	Gid   string
+	Count int          // 未读消息数
+	Last  *ChatMessage // 最后一条未读消息
+}
+
+// 消息是否是自己发的
+// 登录返回后马上会收到离线消息，此时 profile 还没有设置，所以按登录账号判断
+func (u *User) isSelf(m *ChatMessage) bool {
+	sender := u.GetUserById(m.User)
+	return sender != nil && strings.EqualFold(sender.Account, u.account)
+}
+
+// 丢弃最久没有消息的会话
+func (u *User) dropOldestUnread() {
+	var oldest *UnreadGroup
+	for _, g := range u.unread {
+		if oldest == nil || g.Last.Date.Before(oldest.Last.Date.Time) {
+			oldest = g
+		}
+	}
+	if oldest != nil {
+		delete(u.unread, oldest.Gid)
+	}
+}
+
+// 记录其他用户发来的消息为未读
+// 只记录数量和最后一条消息，重复收到或者比最后一条更早的消息不计数，所以数量是近似的
+func (u *User) addUnread(messages []*ChatMessage) {
+	u.unreadMutex.Lock()
+	defer u.unreadMutex.Unlock()
+
+	if u.unread == nil {
+		u.unread = make(map[string]*UnreadGroup)
+	}
+
+	for _, m := range messages {
+		if u.isSelf(m) {
+			continue
+		}
+		g := u.unread[m.Cgid]
+		if g == nil {
+			if len(u.unread) >= maxUnreadGroups {
+				u.dropOldestUnread()
+			}
+			g = &UnreadGroup{Gid: m.Cgid}
+			u.unread[m.Cgid] = g
+		}
+		if g.Last != nil && (g.Last.Gid == m.Gid || m.Date.Before(g.Last.Date.Time)) {
+			continue
+		}
+		g.Count++
+		g.Last = m
+	}
+}
+
+// 会话的未读消息数
+func (u *User) UnreadCount(gid string) int {
+	u.unreadMutex.Lock()
+	defer u.unreadMutex.Unlock()
+
+	if g := u.unread[gid]; g != nil {
+		return g.Count
+	}
+	return 0
+}
+
+// 会话的未读消息数和最后一条未读消息，没有未读消息时返回 nil
+func (u *User) Unread(gid string) *UnreadGroup {
+	u.unreadMutex.Lock()
+	defer u.unreadMutex.Unlock()
+
+	g := u.unread[gid]
+	if g == nil {
+		return nil
+	}
+	v := *g
+	return &v
+}
+
+// 所有有未读消息的会话，最近有消息的排在前面
+func (u *User) UnreadGroups() []*UnreadGroup {
+	u.unreadMutex.Lock()
+	defer u.unreadMutex.Unlock()
+
+	groups := make([]*UnreadGroup, 0, len(u.unread))
+	for _, g := range u.unread {
+		v := *g
+		groups = append(groups, &v)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Last.Date.After(groups[j].Last.Date.Time)
+	})
+	return groups
+}
+
+// 把会话标记为已读，并通知服务器
+func (u *User) MarkRead(gid string) error {
+	u.unreadMutex.Lock()
+	delete(u.unread, gid)
+	u.unreadMutex.Unlock()
+
+	if !u.Client.Supports(FeatureReadReceipt) {
+		return nil
+	}
+	readRequest := NewChatRequest(u.profile.Id, &SetMessagesReadAPI{Gid: gid})
+	return u.Client.Send(readRequest)
+}
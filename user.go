@@ -1,6 +1,7 @@
 package xxc
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -9,28 +10,69 @@ import (
 )
 
 type User struct {
-	Client *Client
+	Client  *Client
+	Store   Store           // 本地存储，可以为空
+	Tracker *MessageTracker // 记录已处理的消息，用于去重和断线后补齐消息
+	Outbox  *Outbox         // 发件箱，用于 QueueMessage、SayToGroup 和 SayToUser，可以为空
+	Pinyin  PinyinFunc      // 汉字转拼音，用于 SearchUsers，为空时不支持拼音搜索
 
+	account     string // 登录使用的账号，登录前就已确定
 	profile     *UserProfile
-	loginFinish chan struct{} // 登录完成后关闭
+	loginFinish chan struct{}           // 登录完成后关闭
+	catchUpFrom map[string]*ChatMessage // 登录前 Tracker 中每个会话最后处理的消息，CatchUp 从这里开始
 
 	usersMutex sync.RWMutex
 	users      map[int]*UserProfile // 所有用户
+	userIndex  *userIndex
+
+	deptMutex sync.RWMutex
+	depts     map[int]*Dept // 所有部门
 
 	groupMutex sync.RWMutex
 	groups     map[string]*ChatGroup // 所有会话
+
+	createMutex sync.Mutex
+	creating    map[int]*one2oneCreation // 正在创建的 one2one 会话，按对方用户ID索引
+
+	handlerMutex    sync.RWMutex
+	messageHandlers []func(*ChatMessage)
+	dispatchMutex   sync.Mutex // 保证消息处理函数按顺序调用
+
+	unreadMutex sync.Mutex
+	unread      map[string]*UnreadGroup // 每个会话的未读消息数和最后一条未读消息
 }
 
+// 用户选项
+type UserOptions struct {
+	Store   Store           // 本地存储，可以为空
+	Tracker *MessageTracker // 重连时传入上次登录使用的 Tracker，可以通过 CatchUp 补齐断线期间的消息；为空时新建
+	Outbox  *Outbox         // 发件箱，用于 QueueMessage、SayToGroup 和 SayToUser，登录后自动重发其中未发送的消息
+	Pinyin  PinyinFunc      // 汉字转拼音，本库不提供实现，为空时不支持拼音搜索
+}
+
+type one2oneCreation struct {
+	group *ChatGroup
+	err   error
+	done  chan struct{}
+}
+
+// 写本地存储可能比较慢，不在持有锁时进行
 func (u *User) updateUsers(users []*UserProfile) {
 	u.usersMutex.Lock()
-	defer u.usersMutex.Unlock()
-
 	if u.users == nil {
 		u.users = make(map[int]*UserProfile)
 	}
 	for _, user := range users {
+		if old := u.users[user.Id]; old != nil {
+			u.userIndex.remove(old)
+		}
 		u.users[user.Id] = user
+		u.userIndex.add(user)
 	}
+	snapshot := append([]*UserProfile(nil), users...)
+	u.usersMutex.Unlock()
+
+	u.saveUsers(snapshot)
 }
 
 func (u *User) getUsersList() []*UserProfile {
@@ -45,8 +87,6 @@ func (u *User) getUsersList() []*UserProfile {
 }
 func (u *User) updateGroups(groups []*ChatGroup) {
 	u.groupMutex.Lock()
-	defer u.groupMutex.Unlock()
-
 	if u.groups == nil {
 		u.groups = make(map[string]*ChatGroup)
 	}
@@ -55,6 +95,45 @@ func (u *User) updateGroups(groups []*ChatGroup) {
 		u.groups[group.Gid] = group
 		log.Printf("group: gid:%s name:%s type:%s", group.Gid, group.Name, group.Type)
 	}
+	snapshot := append([]*ChatGroup(nil), groups...)
+	u.groupMutex.Unlock()
+
+	u.saveGroups(snapshot)
+}
+
+func (u *User) saveUsers(users []*UserProfile) {
+	if u.Store == nil {
+		return
+	}
+	if err := u.Store.SaveUsers(users); err != nil {
+		log.Printf("save users failed: %s", err)
+	}
+}
+
+func (u *User) saveGroups(groups []*ChatGroup) {
+	if u.Store == nil {
+		return
+	}
+	if err := u.Store.SaveGroups(groups); err != nil {
+		log.Printf("save groups failed: %s", err)
+	}
+}
+
+func (u *User) saveMessages(messages []*ChatMessage) {
+	if u.Store == nil {
+		return
+	}
+	if err := u.Store.SaveMessages(messages); err != nil {
+		log.Printf("save messages failed: %s", err)
+	}
+}
+
+// 查询本地存储的消息
+func (u *User) QueryMessages(q *MessageQuery) ([]*ChatMessage, error) {
+	if u.Store == nil {
+		return nil, errors.New("no store")
+	}
+	return u.Store.QueryMessages(q)
 }
 
 func (u *User) GetGroup(gid string) *ChatGroup {
@@ -63,11 +142,25 @@ func (u *User) GetGroup(gid string) *ChatGroup {
 	return u.groups[gid]
 }
 
+// one2one 会话的 gid 有两种形式: "me&peer" 和其他客户端创建的 "peer&me"
+func (u *User) one2oneGids(id int) []string {
+	return []string{
+		fmt.Sprintf("%d&%d", u.profile.Id, id),
+		fmt.Sprintf("%d&%d", id, u.profile.Id),
+	}
+}
+
 // 查找用户所在的 one2one 会话组
 func (u *User) QueryOne2OneGroup(id int) *ChatGroup {
 	u.groupMutex.RLock()
 	defer u.groupMutex.RUnlock()
 
+	for _, gid := range u.one2oneGids(id) {
+		if group := u.groups[gid]; group != nil {
+			return group
+		}
+	}
+
 	for _, group := range u.groups {
 		if group.Type == "one2one" {
 			for _, member := range group.Members {
@@ -80,25 +173,69 @@ func (u *User) QueryOne2OneGroup(id int) *ChatGroup {
 	return nil
 }
 
-// 创建一个 one2one Group
-func (u *User) CreateOne2OneGroup(id int) (gid string, err error) {
-	gid = fmt.Sprintf("%d&%d", u.profile.Id, id)
-	createGroupRequest := &Request{
-		UserID: u.profile.Id,
-		Module: "chat",
-		Method: "create",
-		Params: []interface{}{
-			gid,
-			"",
-			"one2one",
-			[]int{u.profile.Id, id},
-			0,
-			false,
-		},
+// 创建一个 one2one Group，等待服务器返回创建好的会话
+// 同一用户的并发创建请求会合并为一次
+func (u *User) CreateOne2OneGroup(id int) (*ChatGroup, error) {
+	if group := u.QueryOne2OneGroup(id); group != nil {
+		return group, nil
+	}
+
+	u.createMutex.Lock()
+	if c, ok := u.creating[id]; ok {
+		u.createMutex.Unlock()
+		<-c.done
+		return c.group, c.err
 	}
+	if u.creating == nil {
+		u.creating = make(map[int]*one2oneCreation)
+	}
+	c := &one2oneCreation{
+		done: make(chan struct{}),
+	}
+	u.creating[id] = c
+	u.createMutex.Unlock()
+
+	c.group, c.err = u.createOne2OneGroup(id)
 
-	err = u.Client.Send(createGroupRequest)
-	return
+	u.createMutex.Lock()
+	delete(u.creating, id)
+	u.createMutex.Unlock()
+	close(c.done)
+	return c.group, c.err
+}
+
+// 其他客户端创建会话时也会推送 chat.create，按成员区分是不是这次创建的返回
+// 不是的推送由 OnChatCreate 处理；失败的返回没有会话信息，无法区分，认为是这次创建的
+func (u *User) createOne2OneGroup(id int) (*ChatGroup, error) {
+	createAPI := &CreateAPI{
+		Gid:     u.one2oneGids(id)[0],
+		Type:    "one2one",
+		Members: []int{u.profile.Id, id},
+	}
+
+	me := u.profile.Id
+	match := func(resp *Response) bool {
+		if !resp.Succeed() {
+			return true
+		}
+		group, err := createAPI.Decode(resp)
+		return err == nil && group.Type == "one2one" && group.IsInGroup(id) && group.IsInGroup(me)
+	}
+	resp, err := u.Client.callMatch(NewChatRequest(me, createAPI), match)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := createAPI.Decode(resp)
+	if err != nil {
+		return nil, err
+	}
+	if group.Type != "one2one" || !group.IsInGroup(id) {
+		return nil, fmt.Errorf("create one2one group with %d failed: unexpected reply %s", id, resp)
+	}
+
+	u.updateGroups([]*ChatGroup{group})
+	return group, nil
 }
 
 // 刷新用户列表
@@ -133,7 +270,6 @@ func (u *User) OnChatGetList(resp *Response) {
 	close(u.loginFinish)
 }
 
-//
 // 接收聊天信息
 func (u *User) OnChatMessage(resp *Response) {
 	var messages []*ChatMessage
@@ -145,14 +281,49 @@ func (u *User) OnChatMessage(resp *Response) {
 
 	if resp.Succeed() {
 		for _, m := range messages {
-			log.Printf("OnChatMessage: g<%s>, u<%d>, d<%d>, t<%s>, ct<%s>, c<%s>", m.Cgid, m.User, m.Date, m.Type, m.ContentType, m.Content)
+			log.Printf("OnChatMessage: g<%s>, u<%d>, d<%d>, t<%s>, ct<%s>, c<%s>", m.Cgid, m.User, m.Date.Unix(), m.Type, m.ContentType, m.Content)
 		}
+		u.dispatchMessages(messages)
 	} else {
 		log.Printf("OnChatMessage: <%s,%s>", resp.Result, resp.Message)
 	}
 
 }
 
+// 注册消息处理函数，收到的新消息(包括 CatchUp 补齐的消息)按顺序回调
+// 处理函数在接收消息的 goroutine 中调用(CatchUp 时在调用 CatchUp 的 goroutine 中)，调用期间持有分发锁，
+// 不能在其中等待服务器返回(如 Client.Call、CreateOne2OneGroup)，否则接收消息的 goroutine 会被阻塞而死锁，
+// 需要时另起 goroutine 处理，如 bot.Router
+func (u *User) HandleMessage(f func(*ChatMessage)) {
+	u.handlerMutex.Lock()
+	defer u.handlerMutex.Unlock()
+	u.messageHandlers = append(u.messageHandlers, f)
+}
+
+func (u *User) dispatchMessages(messages []*ChatMessage) {
+	u.dispatchMutex.Lock()
+	defer u.dispatchMutex.Unlock()
+
+	u.handlerMutex.RLock()
+	defer u.handlerMutex.RUnlock()
+
+	// 还没有处理函数时，不记录为已处理，CatchUp 时可以再次补齐
+	if len(u.messageHandlers) == 0 {
+		u.saveMessages(messages)
+		u.addUnread(messages)
+		return
+	}
+
+	messages = u.Tracker.track(messages)
+	u.saveMessages(messages)
+	u.addUnread(messages)
+	for _, m := range messages {
+		for _, h := range u.messageHandlers {
+			h(m)
+		}
+	}
+}
+
 // 接收新建组信息
 func (u *User) OnChatCreate(resp *Response) {
 	var group *ChatGroup
@@ -180,6 +351,7 @@ func (u *User) OnChatLogin(resp *Response) {
 		return
 	}
 	log.Printf("OnChatLogin: %s<%s>", user.Account, user.Realname)
+	u.saveUsers([]*UserProfile{&user})
 }
 
 // 接收其他用户登录信息
@@ -191,41 +363,44 @@ func (u *User) OnChatLogout(resp *Response) {
 		return
 	}
 	log.Printf("OnChatLogout: %s<%s>", user.Account, user.Realname)
+	u.saveUsers([]*UserProfile{&user})
 }
 
+// SayToGroup、SayToUser 发送的文本消息，设置了 Outbox 时经过发件箱，见 QueueMessage
 func (u *User) say(gid string, content string) error {
-	message := &ChatMessage{
+	_, err := u.QueueMessage(gid, Text(content))
+	return err
+}
+
+// 向会话发送消息，根据消息类型设置 ContentType
+// 不经过发件箱，需要在失败后重发的消息使用 QueueMessage
+func (u *User) SendMessage(gid string, m Message) error {
+	return u.sendChatMessage(u.newChatMessage(gid, m))
+}
+
+func (u *User) newChatMessage(gid string, m Message) *ChatMessage {
+	return &ChatMessage{
 		Gid:         uuid.NewV4().String(),
 		Cgid:        gid,
 		Type:        "normal",
-		ContentType: "text",
-		Date:        0,
+		ContentType: m.ContentType(),
+		Date:        Timestamp{},
 		User:        u.profile.Id,
-		Content:     content,
-	}
-
-	var params struct {
-		Messages []*ChatMessage `json:"messages"`
-	}
-	params.Messages = []*ChatMessage{
-		message,
+		Content:     m.Content(),
 	}
+}
 
-	messageRequest := &Request{
-		UserID: u.profile.Id,
-		Module: "chat",
-		Method: "message",
-		Params: params,
-	}
+func (u *User) sendChatMessage(message *ChatMessage) error {
+	messageRequest := NewChatRequest(u.profile.Id, &MessageAPI{
+		Messages: []*ChatMessage{
+			message,
+		},
+	})
 	return u.Client.Send(messageRequest)
 }
 
 func (u *User) ReloadUserList() []*UserProfile {
-	request := &Request{
-		UserID: u.profile.Id,
-		Module: "chat",
-		Method: "usergetlist",
-	}
+	request := NewChatRequest(u.profile.Id, &UserGetListAPI{})
 
 	response, err := u.Client.Call(request)
 	if err == nil {
@@ -243,16 +418,18 @@ func (u *User) SayToGroup(gid string, content string) error {
 	return u.say(gid, content)
 }
 
-// 通过用户account，查找用户
+// 通过用户account，查找用户，区分大小写
 func (u *User) GetUserByAccount(account string) *UserProfile {
 	u.usersMutex.RLock()
 	defer u.usersMutex.RUnlock()
-	for _, user := range u.users {
-		if user.Account == account {
-			return user
-		}
-	}
-	return nil
+	return u.userIndex.account[account]
+}
+
+// 通过用户ID，查找用户
+func (u *User) GetUserById(id int) *UserProfile {
+	u.usersMutex.RLock()
+	defer u.usersMutex.RUnlock()
+	return u.users[id]
 }
 
 func (u *User) SayToUser(account string, content string) error {
@@ -267,11 +444,11 @@ func (u *User) SayToUser(account string, content string) error {
 
 	// 走到这里，说明这两个人之前没有私聊过
 	// 先创建一个 one2one Group
-	gid, err := u.CreateOne2OneGroup(user.Id)
+	group, err := u.CreateOne2OneGroup(user.Id)
 	if err != nil {
 		return err
 	}
-	return u.say(gid, content)
+	return u.say(group.Gid, content)
 }
 
 func (u *User) GetProfile() *UserProfile {
@@ -283,11 +460,34 @@ func (u *User) Fini() error {
 }
 
 func CreateUser(client *Client) (*User, error) {
+	return CreateUserWithOptions(client, &UserOptions{})
+}
+
+// 创建用户，登录过程中收到的消息、会话和用户信息都会记录到 store
+func CreateUserWithStore(client *Client, store Store) (*User, error) {
+	return CreateUserWithOptions(client, &UserOptions{Store: store})
+}
+
+func CreateUserWithOptions(client *Client, opts *UserOptions) (*User, error) {
+	tracker := opts.Tracker
+	if tracker == nil {
+		tracker = NewMessageTracker()
+	}
+
+	// 在任何消息被记录之前取补齐的起点，登录后收到的新消息会更新 Tracker
 	user := &User{
+		Store:       opts.Store,
+		Tracker:     tracker,
+		Outbox:      opts.Outbox,
+		Pinyin:      opts.Pinyin,
+		account:     client.clientConfig.User,
+		userIndex:   newUserIndex(),
 		loginFinish: make(chan struct{}),
+		catchUpFrom: tracker.lastMessages(),
 	}
 	mux := &ClientMux{}
 	mux.HandleFunc("chat.usergetlist", user.OnChatUserGetList)
+	mux.HandleFunc("chat.deptgetlist", user.OnChatDeptGetList)
 	mux.HandleFunc("chat.getlist", user.OnChatGetList)
 	mux.HandleFunc("chat.message", user.OnChatMessage)
 	mux.HandleFunc("chat.create", user.OnChatCreate)
@@ -306,5 +506,9 @@ func CreateUser(client *Client) (*User, error) {
 
 	// 登录成功后，会依次收到三条消息: chat.usergetlist, chat.getlist, chat.message
 	<-user.loginFinish
+
+	if err := user.FlushOutbox(); err != nil {
+		log.Printf("flush outbox failed: %s", err)
+	}
 	return user, nil
 }
@@ -6,6 +6,28 @@ import (
 )
 
 func hashPassword(s string) string {
-	h := md5.Sum([]byte(s))
+	return hashBytes([]byte(s))
+}
+
+func hashBytes(b []byte) string {
+	h := md5.Sum(b)
 	return hex.EncodeToString(h[:])
 }
+
+func containsInt(l []int, v int) bool {
+	for _, e := range l {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(l []string, v string) bool {
+	for _, e := range l {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
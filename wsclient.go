@@ -6,67 +6,135 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type call struct {
-	resp *Response
-	err  error
-	done chan *call
+	match func(*Response) bool // 判断返回是否属于此次调用，为空时同名的返回都属于此次调用
+	resp  *Response
+	err   error
+	done  chan *call // 缓冲为 1，唤醒时不会阻塞，也不会因为调用方还没有开始等待而丢失
 }
 
+// 等待服务器返回的默认时间
+const defaultCallTimeout = 30 * time.Second
+
+// 等待返回的调用，按方法名索引
+// 没有 match 的调用同一时刻只能有一个；有 match 的调用可以同时有多个，按 match 区分返回
 type sessions struct {
 	sync.Mutex
-	m map[string]*call
+	m map[string][]*call
 }
 
-func (ss *sessions) get(name string) *call {
+// 取出返回所属的调用，没有时返回 nil，返回会作为通知处理
+func (ss *sessions) take(name string, resp *Response) *call {
 	ss.Lock()
 	defer ss.Unlock()
-	return ss.m[name]
+	for i, c := range ss.m[name] {
+		if c.match == nil || c.match(resp) {
+			ss.m[name] = append(ss.m[name][:i:i], ss.m[name][i+1:]...)
+			return c
+		}
+	}
+	return nil
 }
 
-func (ss *sessions) set(name string, c *call) bool {
+func (ss *sessions) add(name string, c *call) bool {
 	ss.Lock()
 	defer ss.Unlock()
 	if ss.m == nil {
-		ss.m = make(map[string]*call)
+		ss.m = make(map[string][]*call)
 	}
 
-	if _, ok := ss.m[name]; ok {
-		return false
+	for _, pending := range ss.m[name] {
+		if pending.match == nil || c.match == nil {
+			return false
+		}
 	}
-	ss.m[name] = c
+	ss.m[name] = append(ss.m[name], c)
 	return true
 }
 
-func (ss *sessions) delete(name string) {
+func (ss *sessions) remove(name string, c *call) {
 	ss.Lock()
 	defer ss.Unlock()
-	delete(ss.m, name)
+	for i, pending := range ss.m[name] {
+		if pending == c {
+			ss.m[name] = append(ss.m[name][:i:i], ss.m[name][i+1:]...)
+			break
+		}
+	}
+	if len(ss.m[name]) == 0 {
+		delete(ss.m, name)
+	}
 }
 
 func (ss *sessions) clearAll() []*call {
 	var m []*call
 	ss.Lock()
 	defer ss.Unlock()
-	for _, v := range ss.m {
-		m = append(m, v)
+	for _, l := range ss.m {
+		m = append(m, l...)
 	}
 	ss.m = nil
 	return m
 }
 
-type wsClient struct {
+// 传输层，负责收发解密后的帧
+type Transport interface {
+	ReadFrame() ([]byte, error)
+	WriteFrame(data []byte) error
+	Close() error
+}
+
+// 基于 websocket 的传输层，帧使用 aes 加密
+type wsTransport struct {
 	conn  *websocket.Conn
 	token []byte
+}
+
+func (t *wsTransport) ReadFrame() ([]byte, error) {
+	_, message, err := t.conn.ReadMessage()
+	if err != nil {
+		t.conn.Close()
+		return nil, err
+	}
+	return aesDecrypt(message, t.token)
+}
+
+func (t *wsTransport) WriteFrame(message []byte) error {
+	data, err := aesEncrypt(message, t.token)
+	if err != nil {
+		return err
+	}
+	err = t.conn.WriteMessage(websocket.BinaryMessage, data)
+	if err != nil {
+		t.conn.Close()
+	}
+	return err
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}
+
+type wsClient struct {
+	transport Transport
 
 	rdMutex sync.Mutex
 	wrMutex sync.Mutex
 
 	ss      *sessions
 	handler Handler
+	tracer  *Tracer // 可以为空
+	metrics Metrics
+
+	callTimeout time.Duration // 等待返回的时间，为 0 时使用 defaultCallTimeout
+
+	// 服务器返回失败时回调，在分发前调用，可以为空
+	onFailure func(*Response)
 
 	// 读取信息失败时回调
 	OnHandleError func(error)
@@ -76,15 +144,13 @@ func (ws *wsClient) readMessage() (*Response, error) {
 	ws.wrMutex.Lock()
 	defer ws.wrMutex.Unlock()
 
-	_, message, err := ws.conn.ReadMessage()
+	message, err := ws.transport.ReadFrame()
 	if err != nil {
-		ws.conn.Close()
 		return nil, err
 	}
 
-	message, err = aesDecrypt(message, []byte(ws.token))
-	if err != nil {
-		return nil, err
+	if ws.tracer != nil {
+		ws.tracer.Trace(TraceIn, message)
 	}
 
 	return parseResponse(message)
@@ -95,29 +161,34 @@ func (ws *wsClient) writeMessage(req *Request) error {
 	defer ws.rdMutex.Unlock()
 
 	s := req.String()
-	data, err := aesEncrypt([]byte(s), []byte(ws.token))
-	if err != nil {
-		return err
-	}
-	err = ws.conn.WriteMessage(websocket.BinaryMessage, data)
-	if err != nil {
-		ws.conn.Close()
+	ws.metrics.FrameOut(req.MethodName())
+	if ws.tracer != nil {
+		ws.tracer.Trace(TraceOut, []byte(s))
 	}
-	return err
+	return ws.transport.WriteFrame([]byte(s))
 }
 
 func (ws *wsClient) wakeupAll(err error) {
 	ss := ws.ss.clearAll()
 	for _, call := range ss {
 		call.err = err
-		select {
-		case call.done <- call:
-		default:
-		}
+		call.done <- call
 	}
 }
 
+func (ws *wsClient) timeout() time.Duration {
+	if ws.callTimeout <= 0 {
+		return defaultCallTimeout
+	}
+	return ws.callTimeout
+}
+
 func (ws *wsClient) handleMessage() {
+	// 读取结束后才关闭 tracer，避免关闭后还在记录
+	if ws.tracer != nil {
+		defer ws.tracer.Close()
+	}
+
 	for {
 		resp, err := ws.readMessage()
 		if err != nil {
@@ -129,20 +200,27 @@ func (ws *wsClient) handleMessage() {
 			return
 		}
 		name := resp.MethodName()
-		call := ws.ss.get(name)
+		ws.metrics.FrameIn(name)
+		if !resp.Succeed() && ws.onFailure != nil {
+			ws.onFailure(resp)
+		}
+		call := ws.ss.take(name, resp)
 		if call != nil {
 			log.Printf("[response] %s", name)
 			call.resp = resp
 			call.done <- call
 		} else {
 			log.Printf("[notify] %s", name)
+			start := time.Now()
 			ws.handler.ServeXX(resp)
+			ws.metrics.HandlerDone(name, time.Since(start))
 		}
 	}
 }
 
+// 关闭连接，tracer 在接收消息的 goroutine 退出时关闭
 func (ws *wsClient) Close() {
-	ws.conn.Close()
+	ws.transport.Close()
 }
 
 func (ws *wsClient) Send(req *Request) error {
@@ -150,23 +228,42 @@ func (ws *wsClient) Send(req *Request) error {
 	return ws.writeMessage(req)
 }
 
-func (ws *wsClient) Call(req *Request) (*Response, error) {
+func (ws *wsClient) Call(req *Request) (resp *Response, err error) {
+	return ws.call(req, nil)
+}
+
+// match 不为空时只接受 match 返回 true 的返回，其他同名的返回作为通知处理
+func (ws *wsClient) call(req *Request, match func(*Response) bool) (resp *Response, err error) {
 	log.Printf("[call] %s", req.MethodName())
 	name := req.MethodName()
 	c := &call{
-		done: make(chan *call),
+		match: match,
+		done:  make(chan *call, 1),
 	}
 
-	if ok := ws.ss.set(name, c); !ok {
+	if ok := ws.ss.add(name, c); !ok {
 		return nil, fmt.Errorf("cannot call %s when previous call is pending", name)
 	}
-	defer ws.ss.delete(name)
+	defer ws.ss.remove(name, c)
+
+	start := time.Now()
+	ws.metrics.PendingCalls(1)
+	defer func() {
+		ws.metrics.PendingCalls(-1)
+		ws.metrics.CallDone(name, time.Since(start), err)
+	}()
 
 	if err := ws.writeMessage(req); err != nil {
 		return nil, err
 	}
 
-	<-c.done
+	timer := time.NewTimer(ws.timeout())
+	defer timer.Stop()
+	select {
+	case <-c.done:
+	case <-timer.C:
+		return nil, fmt.Errorf("call %s timeout after %s", name, ws.timeout())
+	}
 
 	if c.err != nil {
 		return nil, c.err
@@ -178,7 +275,7 @@ func (ws *wsClient) Call(req *Request) (*Response, error) {
 	return c.resp, nil
 }
 
-func createWsClient(httpUrl string, port int, token []byte, handler Handler) (*wsClient, error) {
+func createWsClient(httpUrl string, port int, token []byte, handler Handler, tracer *Tracer, metrics Metrics, onFailure func(*Response)) (*wsClient, error) {
 	o, err := url.Parse(httpUrl)
 	if err != nil {
 		return nil, err
@@ -197,14 +294,24 @@ func createWsClient(httpUrl string, port int, token []byte, handler Handler) (*w
 		return nil, err
 	}
 
+	transport := &wsTransport{
+		conn:  conn,
+		token: token,
+	}
+	return newWsClient(transport, handler, tracer, metrics, onFailure), nil
+}
+
+func newWsClient(transport Transport, handler Handler, tracer *Tracer, metrics Metrics, onFailure func(*Response)) *wsClient {
 	ws := &wsClient{
-		conn:    conn,
-		token:   token,
-		ss:      &sessions{},
-		handler: handler,
+		transport: transport,
+		ss:        &sessions{},
+		handler:   handler,
+		tracer:    tracer,
+		metrics:   metrics,
+		onFailure: onFailure,
 	}
 
 	go ws.handleMessage()
 
-	return ws, nil
+	return ws
 }